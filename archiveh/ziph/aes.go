@@ -0,0 +1,460 @@
+package ziph
+
+// WinZip AES encryption, see https://www.winzip.com/en/support/aes-encryption/
+// An encrypted entry has compression method 99 and an extra field (0x9901) that holds
+// the AES strength and the actual compression method. The entry data is:
+// salt, password verification value, encrypted (compressed) data, authentication code.
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	aesExtraID        = 0x9901
+	aesIterations     = 1000
+	aesMACLen         = 10
+	aesMethod         = 99
+	aesPVLen          = 2
+	aesStrength256    = 3
+	aesVendorVersion1 = 1
+	aesVendorVersion2 = 2
+	aesZipVersion     = 51
+	extTimeExtraID    = 0x5455
+)
+
+// errAuthentication is wrapped by the error returned when the authentication code of a
+// WinZip AES encrypted entry does not match the data.
+var errAuthentication = errors.New("authentication code mismatch")
+
+// PasswordError is returned when an encrypted entry is extracted without a password, or
+// with a password that does not match the one used to encrypt the entry.
+type PasswordError struct {
+	Name string
+}
+
+func (e *PasswordError) Error() string {
+	return fmt.Sprintf("ziph: incorrect or missing password for entry: %s", e.Name)
+}
+
+// aesExtra is the parsed WinZip AES extra field.
+type aesExtra struct {
+	vendorVersion uint16
+	strength      uint8
+	method        uint16
+}
+
+// aesReadCloser closes, and reads from, the decompressor of an encrypted entry. A
+// decompressor can stop reading before the end of the encrypted data, I.E. flate stops at
+// the final block, so the rest of the data is read and the authentication code verified when
+// the decompressor returns io.EOF, and on Close.
+type aesReadCloser struct {
+	ar *aesReader
+	rc io.ReadCloser
+}
+
+// aesReader decrypts entry data read from r, and verifies the authentication code, read
+// from raw, as soon as all of the data has been read from r.
+type aesReader struct {
+	// err is the authentication error, returned by every Read once set.
+	err      error
+	mac      hash.Hash
+	name     string
+	r        *io.LimitedReader
+	raw      io.Reader
+	stream   cipher.Stream
+	verified bool
+}
+
+// aesWriter encrypts data and writes it to w, updating the authentication code.
+type aesWriter struct {
+	mac    hash.Hash
+	stream cipher.Stream
+	w      io.Writer
+}
+
+// crcReader verifies the CRC32 of data read from rc when rc returns io.EOF.
+type crcReader struct {
+	hash hash.Hash32
+	rc   io.ReadCloser
+	want uint32
+}
+
+// winzipCTR is AES in counter mode as used by WinZip; the counter is little endian and
+// starts at 1, which differs from crypto/cipher.NewCTR.
+type winzipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	pos     int
+	stream  [aes.BlockSize]byte
+}
+
+func (arc *aesReadCloser) Close() error {
+	err := arc.ar.drain()
+	if cerr := arc.rc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (arc *aesReadCloser) Read(p []byte) (int, error) {
+	n, err := arc.rc.Read(p)
+	if err == io.EOF {
+		if derr := arc.ar.drain(); derr != nil {
+			return n, derr
+		}
+	}
+	return n, err
+}
+
+func (ar *aesReader) Read(p []byte) (int, error) {
+	if ar.err != nil {
+		return 0, ar.err
+	}
+	n, err := ar.r.Read(p)
+	if n > 0 {
+		ar.mac.Write(p[:n])
+		ar.stream.XORKeyStream(p[:n], p[:n])
+	}
+	if ar.r.N == 0 && !ar.verified {
+		code := make([]byte, aesMACLen)
+		if _, err := io.ReadFull(ar.raw, code); err != nil {
+			return n, err
+		}
+		if !hmac.Equal(code, ar.mac.Sum(nil)[:aesMACLen]) {
+			ar.err = fmt.Errorf("ziph: %w for entry: %s", errAuthentication, ar.name)
+			return n, ar.err
+		}
+		ar.verified = true
+	}
+	if err == io.EOF && !ar.verified {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// drain reads, and discards, the rest of the encrypted data, so the authentication code is
+// verified; it returns the authentication error, if any.
+func (ar *aesReader) drain() error {
+	if !ar.verified && ar.err == nil {
+		if _, err := io.Copy(io.Discard, ar); err != nil {
+			return err
+		}
+	}
+	return ar.err
+}
+
+func (aw *aesWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	aw.stream.XORKeyStream(buf, p)
+	aw.mac.Write(buf)
+	return aw.w.Write(buf)
+}
+
+func (cr *crcReader) Close() error {
+	return cr.rc.Close()
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.rc.Read(p)
+	cr.hash.Write(p[:n])
+	if err == io.EOF && cr.hash.Sum32() != cr.want {
+		return n, zip.ErrChecksum
+	}
+	return n, err
+}
+
+func (c *winzipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			for j := 0; j < 8; j++ {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// aesKeys derives the encryption key, authentication key, and password verification
+// value from the password and salt.
+func aesKeys(password string, salt []byte, keyLen int) ([]byte, []byte, []byte) {
+	dk := pbkdf2SHA1([]byte(password), salt, aesIterations, 2*keyLen+aesPVLen)
+	return dk[:keyLen], dk[keyLen : 2*keyLen], dk[2*keyLen:]
+}
+
+// aesKeyLen returns the key length in bytes for an AES strength; the salt length is half
+// of the key length.
+func aesKeyLen(strength uint8) (int, error) {
+	switch strength {
+	case 1:
+		return 16, nil
+	case 2:
+		return 24, nil
+	case aesStrength256:
+		return 32, nil
+	}
+	return 0, fmt.Errorf("ziph: invalid AES strength: %d", strength)
+}
+
+// isAES returns true if the entry is WinZip AES encrypted.
+func isAES(zipFile *zip.File) bool {
+	return zipFile.Method == aesMethod && zipFile.Flags&0x1 != 0
+}
+
+// openAES opens a WinZip AES encrypted entry for reading, returning the decrypted and
+// decompressed data. A *PasswordError is returned if the password is wrong.
+func openAES(zipFile *zip.File, password string) (io.ReadCloser, error) {
+	extra, err := parseAESExtra(zipFile.Extra)
+	if err != nil {
+		return nil, fmt.Errorf("%w, entry: %s", err, zipFile.Name)
+	}
+	if password == "" {
+		return nil, &PasswordError{Name: zipFile.Name}
+	}
+	keyLen, err := aesKeyLen(extra.strength)
+	if err != nil {
+		return nil, err
+	}
+	saltLen := keyLen / 2
+	dataLen := int64(zipFile.CompressedSize64) - int64(saltLen+aesPVLen+aesMACLen)
+	if dataLen < 0 {
+		return nil, fmt.Errorf("ziph: encrypted entry is too short: %s", zipFile.Name)
+	}
+	decomp, err := decompressor(extra.method)
+	if err != nil {
+		return nil, fmt.Errorf("%w, entry: %s", err, zipFile.Name)
+	}
+
+	raw, err := zipFile.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	saltPV := make([]byte, saltLen+aesPVLen)
+	if _, err := io.ReadFull(raw, saltPV); err != nil {
+		return nil, err
+	}
+	encKey, macKey, pv := aesKeys(password, saltPV[:saltLen], keyLen)
+	if !bytes.Equal(pv, saltPV[saltLen:]) {
+		return nil, &PasswordError{Name: zipFile.Name}
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ar := &aesReader{
+		mac:    hmac.New(sha1.New, macKey),
+		name:   zipFile.Name,
+		r:      &io.LimitedReader{R: raw, N: dataLen},
+		raw:    raw,
+		stream: &winzipCTR{block: block, pos: aes.BlockSize},
+	}
+	var rc io.ReadCloser = &aesReadCloser{ar: ar, rc: decomp(ar)}
+	// AE-1 stores the CRC; AE-2 stores zero and relies on the authentication code.
+	if extra.vendorVersion == aesVendorVersion1 {
+		return &crcReader{hash: crc32.NewIEEE(), rc: rc, want: zipFile.CRC32}, nil
+	}
+	return rc, nil
+}
+
+// openEntry opens a zip.File for reading, decrypting it if required.
+func openEntry(zipFile *zip.File, password string) (io.ReadCloser, error) {
+	if isAES(zipFile) {
+		return openAES(zipFile, password)
+	}
+	return zipFile.Open()
+}
+
+// parseAESExtra finds and parses the WinZip AES extra field.
+func parseAESExtra(extra []byte) (*aesExtra, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == aesExtraID && size >= 7 && string(extra[2:4]) == "AE" {
+			return &aesExtra{
+				vendorVersion: binary.LittleEndian.Uint16(extra[0:2]),
+				strength:      extra[4],
+				method:        binary.LittleEndian.Uint16(extra[5:7]),
+			}, nil
+		}
+		extra = extra[size:]
+	}
+	return nil, fmt.Errorf("ziph: missing or invalid AES extra field")
+}
+
+// pbkdf2SHA1 implements PBKDF2 (RFC 8018) with HMAC-SHA1.
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	dk := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	var index [4]byte
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index[:], uint32(block))
+		prf.Write(index[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+// setRawHeaderFields sets the header fields that zip.Writer.CreateHeader would set, but
+// zip.Writer.CreateRaw does not.
+func setRawHeaderFields(header *zip.FileHeader) {
+	if !utf8.ValidString(header.Name) {
+		header.Flags &^= 0x800
+	} else {
+		for _, r := range header.Name {
+			if r >= utf8.RuneSelf {
+				header.Flags |= 0x800
+				break
+			}
+		}
+	}
+
+	if header.Modified.IsZero() {
+		return
+	}
+	header.ModifiedDate, header.ModifiedTime = timeToMsDosTime(header.Modified)
+	extTime := make([]byte, 9)
+	binary.LittleEndian.PutUint16(extTime[0:2], extTimeExtraID)
+	binary.LittleEndian.PutUint16(extTime[2:4], 5)
+	extTime[4] = 1
+	binary.LittleEndian.PutUint32(extTime[5:9], uint32(header.Modified.Unix()))
+	header.Extra = append(header.Extra, extTime...)
+}
+
+func timeToMsDosTime(t time.Time) (uint16, uint16) {
+	fDate := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	fTime := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return fDate, fTime
+}
+
+// writeAES compresses, using header.Method at the compression level, and encrypts the data from r using WinZip AE-2
+// AES-256, then writes the entry to zipWriter. The encrypted data is staged in a temporary
+// file as the compressed size must be known before the entry header is written.
+func writeAES(zipWriter *zip.Writer, header *zip.FileHeader, r io.Reader, password string, level int) error {
+	comp, err := compressor(header.Method, level)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "ziph-aes-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tmp.Close(); err != nil {
+			fmt.Printf("defer tmp.Close() error:%+v\n", err)
+		}
+		if err := os.Remove(tmp.Name()); err != nil {
+			fmt.Printf("defer os.Remove() error:%+v\n", err)
+		}
+	}()
+
+	keyLen, err := aesKeyLen(aesStrength256)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, keyLen/2)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	encKey, macKey, pv := aesKeys(password, salt, keyLen)
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(salt, pv...)); err != nil {
+		return err
+	}
+
+	aw := &aesWriter{
+		mac:    hmac.New(sha1.New, macKey),
+		stream: &winzipCTR{block: block, pos: aes.BlockSize},
+		w:      tmp,
+	}
+	cw, err := comp(aw)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(cw, r)
+	if err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(aw.mac.Sum(nil)[:aesMACLen]); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:2], aesExtraID)
+	binary.LittleEndian.PutUint16(extra[2:4], 7)
+	binary.LittleEndian.PutUint16(extra[4:6], aesVendorVersion2)
+	copy(extra[6:8], "AE")
+	extra[8] = aesStrength256
+	binary.LittleEndian.PutUint16(extra[9:11], header.Method)
+
+	header.Extra = append(header.Extra, extra...)
+	header.Method = aesMethod
+	header.Flags |= 0x1
+	header.Flags &^= 0x8
+	header.CRC32 = 0
+	header.CompressedSize64 = uint64(size)
+	header.UncompressedSize64 = uint64(n)
+	header.CreatorVersion = header.CreatorVersion&0xff00 | aesZipVersion
+	header.ReaderVersion = aesZipVersion
+	setRawHeaderFields(header)
+
+	w, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+	return err
+}
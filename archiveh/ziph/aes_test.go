@@ -0,0 +1,227 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulfdunn/go-helper/cryptoh/v2"
+)
+
+// TestAESFixture extracts testdata/aes256.zip, which was created with:
+// bsdtar --format zip --options zip:encryption=aes256 --passphrase secret -cf ../aes256.zip lorem.txt dir
+// run from testdata/aes256. It contains both AE-1 (lorem.txt) and AE-2 (dir/tiny.txt) entries.
+func TestAESFixture(t *testing.T) {
+	unzipDir := t.TempDir()
+	_, processedPaths, errs := AsyncUnzipWithOptions(filepath.Join("testdata", "aes256.zip"), unzipDir, 3, 0755,
+		&UnzipOptions{Password: "secret"})
+	result, _ := collectResult(processedPaths, errs)
+	if len(result.Paths) != 3 || len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions paths: %d, errors: %+v", len(result.Paths), result.Errors)
+	}
+
+	for _, name := range []string{"lorem.txt", filepath.Join("dir", "tiny.txt")} {
+		want, err := os.ReadFile(filepath.Join("testdata", "aes256", name))
+		if err != nil {
+			t.Fatalf("reading fixture, error: %+v", err)
+		}
+		got, err := os.ReadFile(filepath.Join(unzipDir, name))
+		if err != nil {
+			t.Fatalf("reading extracted file, error: %+v", err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Errorf("extracted file does not match fixture: %s", name)
+		}
+	}
+}
+
+func TestAESPassword(t *testing.T) {
+	for _, password := range []string{"", "wrong"} {
+		unzipDir := t.TempDir()
+		_, processedPaths, errs := AsyncUnzipWithOptions(filepath.Join("testdata", "aes256.zip"), unzipDir, 3, 0755,
+			&UnzipOptions{Password: password})
+		result, _ := collectResult(processedPaths, errs)
+		if len(result.Errors) != 2 {
+			t.Errorf("password: %s, expected 2 errors, got: %+v", password, result.Errors)
+		}
+		for _, err := range result.Errors {
+			var pe *PasswordError
+			if !errors.As(err, &pe) {
+				t.Errorf("password: %s, error is not a PasswordError: %+v", password, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(unzipDir, "lorem.txt")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("password: %s, file created for entry that could not be decrypted", password)
+		}
+	}
+}
+
+func TestAESZipUnzip(t *testing.T) {
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_aes.zip")
+	trim := filepath.Dir(testFilePaths[0])
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, testFilePaths, []string{trim},
+		&ZipOptions{Password: "pa55word"})
+	result, _ := collectResult(processedPaths, errs)
+	if len(result.Paths) != len(testFilePaths) || len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions paths: %d, errors: %+v", len(result.Paths), result.Errors)
+	}
+
+	zr, err := zip.OpenReader(zipFilePath)
+	if err != nil {
+		t.Fatalf("zip.OpenReader error: %+v", err)
+	}
+	for _, f := range zr.File {
+		extra, err := parseAESExtra(f.Extra)
+		if !isAES(f) || err != nil || extra.vendorVersion != aesVendorVersion2 || extra.strength != aesStrength256 {
+			t.Errorf("entry is not AE-2 AES-256: %s", f.Name)
+		}
+	}
+	zr.Close()
+
+	unzipDir := t.TempDir()
+	_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, len(testFilePaths), 0755,
+		&UnzipOptions{Password: "pa55word"})
+	result, _ = collectResult(processedPaths, errs)
+	if len(result.Paths) != len(testFilePaths) || len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions paths: %d, errors: %+v", len(result.Paths), result.Errors)
+	}
+	for _, tp := range testFilePaths {
+		testInputHash, err := cryptoh.Sha256FileHash(tp)
+		if err != nil {
+			t.Errorf("getting hash, error: %+v", err)
+		}
+		outputFileHash, err := cryptoh.Sha256FileHash(filepath.Join(unzipDir, filepath.Base(tp)))
+		if err != nil {
+			t.Errorf("getting hash, error: %+v", err)
+		}
+		if !bytes.Equal(testInputHash, outputFileHash) {
+			t.Error("input and output hashes are not equal.")
+		}
+	}
+}
+
+// TestAESTampered checks that a changed authentication code, or ciphertext, is detected for
+// both methods; flate stops reading at the final block, before the authentication code.
+func TestAESTampered(t *testing.T) {
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		for _, fromEnd := range []int64{1, aesMACLen + 1} {
+			zipFilePath := tamperedAESArchive(t, method, fromEnd)
+			zr, err := zip.OpenReader(zipFilePath)
+			if err != nil {
+				t.Fatalf("zip.OpenReader error: %+v", err)
+			}
+			rc, err := openEntry(zr.File[0], "pa55word")
+			if err != nil {
+				t.Fatalf("openEntry error: %+v", err)
+			}
+			if _, err := io.Copy(io.Discard, rc); err == nil {
+				t.Errorf("method: %d, fromEnd: %d, io.Copy did not return an error", method, fromEnd)
+			}
+			rc.Close()
+
+			// Close verifies an entry that was not read to the end.
+			rc, err = openEntry(zr.File[0], "pa55word")
+			if err != nil {
+				t.Fatalf("openEntry error: %+v", err)
+			}
+			if _, err := rc.Read(make([]byte, 1)); err != nil {
+				t.Errorf("Read error: %+v", err)
+			}
+			if err := rc.Close(); err == nil {
+				t.Errorf("method: %d, fromEnd: %d, Close did not return an error", method, fromEnd)
+			}
+			zr.Close()
+		}
+	}
+}
+
+// TestVerifyAESTampered checks that Verify reports a changed AE-2 entry, which has no CRC32,
+// and removes the extracted file.
+func TestVerifyAESTampered(t *testing.T) {
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		unzipDir := t.TempDir()
+		_, processedPaths, errs := AsyncUnzipWithOptions(tamperedAESArchive(t, method, 1), unzipDir, 1, 0755,
+			&UnzipOptions{Password: "pa55word", Verify: true})
+		result, _ := collectResult(processedPaths, errs)
+		var ie *IntegrityError
+		if len(result.Errors) != 1 || !errors.As(result.Errors[0], &ie) {
+			t.Errorf("method: %d, expected IntegrityError, got: %+v", method, result.Errors)
+		}
+		if _, err := os.Stat(filepath.Join(unzipDir, "a.txt")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("method: %d, tampered file was not removed", method)
+		}
+	}
+}
+
+// tamperedAESArchive returns the path of an archive with one AE-2 entry, compressed with
+// method, with the byte fromEnd bytes before the end of the entry data changed; 1 changes
+// the authentication code, aesMACLen+1 the ciphertext.
+func tamperedAESArchive(t *testing.T, method uint16, fromEnd int64) string {
+	zipFilePath := filepath.Join(t.TempDir(), "test_aes_tampered.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	zw := zip.NewWriter(f)
+	header := &zip.FileHeader{Name: "a.txt", Method: method}
+	if err := writeAES(zw, header, bytes.NewReader(bytes.Repeat([]byte("tampered "), 1000)), "pa55word", 0); err != nil {
+		t.Fatalf("writeAES error: %+v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	f.Close()
+
+	zr, err := zip.OpenReader(zipFilePath)
+	if err != nil {
+		t.Fatalf("zip.OpenReader error: %+v", err)
+	}
+	offset, err := zr.File[0].DataOffset()
+	if err != nil {
+		t.Fatalf("DataOffset error: %+v", err)
+	}
+	offset += int64(zr.File[0].CompressedSize64) - fromEnd
+	zr.Close()
+
+	b, err := os.ReadFile(zipFilePath)
+	if err != nil {
+		t.Fatalf("ReadFile error: %+v", err)
+	}
+	b[offset] ^= 0xff
+	if err := os.WriteFile(zipFilePath, b, 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	return zipFilePath
+}
+
+// TestPbkdf2SHA1 uses test vectors from RFC 6070.
+func TestPbkdf2SHA1(t *testing.T) {
+	tests := []struct {
+		iterations int
+		keyLen     int
+		password   string
+		salt       string
+		want       string
+	}{
+		{1, 20, "password", "salt", "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{4096, 20, "password", "salt", "4b007901b765489abead49d926f721d065a429c1"},
+		{4096, 25, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt",
+			"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+	}
+	for _, test := range tests {
+		got := hex.EncodeToString(pbkdf2SHA1([]byte(test.password), []byte(test.salt), test.iterations, test.keyLen))
+		if got != test.want {
+			t.Errorf("pbkdf2SHA1 got: %s, want: %s", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package ziph
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// EntryError is returned, on the errors channel of AsyncZip and AsyncUnzip, and by Zip and
+// Unzip, for an error processing a single entry. Path is the walked path when zipping, or
+// the entry name when unzipping. Use errors.As to get the underlying typed error; I.E.
+// *PasswordError or *IntegrityError.
+type EntryError struct {
+	Err  error
+	Path string
+}
+
+// Result is the summary of a Zip or Unzip.
+type Result struct {
+	// Errors are all errors, in the order returned; per entry errors are *EntryError.
+	Errors []error
+	// Paths are the processed paths, in the order processed; the same as the processed
+	// paths channel of AsyncZip and AsyncUnzip.
+	Paths []string
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("ziph: %s: %v", e.Path, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// Unzip is AsyncUnzipWithOptions, but blocks until complete. The returned error joins
+// (errors.Join) all errors in the Result, and is nil if there were none. A nil options
+// uses the defaults.
+func Unzip(inputPath, outputPath string, permDir os.FileMode, options *UnzipOptions) (*Result, error) {
+	// The channels are drained concurrently, so the size only reduces goroutine switches.
+	bufSize := 1
+	if zs, err := GetZipStats(inputPath); err == nil {
+		bufSize = zs.FileCount
+	}
+	_, processedPaths, errs := AsyncUnzipWithOptions(inputPath, outputPath, bufSize, permDir, options)
+	return collectResult(processedPaths, errs)
+}
+
+// Zip is AsyncZipWithOptions, but blocks until complete. The returned error joins
+// (errors.Join) all errors in the Result, and is nil if there were none. A nil options
+// uses the defaults.
+func Zip(zipPath string, paths []string, trimFilepath []string, options *ZipOptions) (*Result, error) {
+	_, processedPaths, errs := AsyncZipWithOptions(zipPath, paths, trimFilepath, options)
+	return collectResult(processedPaths, errs)
+}
+
+// collectResult reads processedPaths and errs until both are closed.
+func collectResult(processedPaths <-chan string, errs <-chan error) (*Result, error) {
+	result := Result{Errors: []error{}, Paths: []string{}}
+	for processedPaths != nil || errs != nil {
+		select {
+		case path, ok := <-processedPaths:
+			if !ok {
+				processedPaths = nil
+				continue
+			}
+			result.Paths = append(result.Paths, path)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			result.Errors = append(result.Errors, err)
+		}
+	}
+	return &result, errors.Join(result.Errors...)
+}
@@ -0,0 +1,64 @@
+package ziph
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulfdunn/go-helper/cryptoh/v2"
+)
+
+func TestZipUnzip(t *testing.T) {
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_blocking.zip")
+	result, err := Zip(zipFilePath, testFilePaths, []string{filepath.Dir(testFilePaths[0])}, nil)
+	if err != nil || len(result.Paths) != len(testFilePaths) || len(result.Errors) != 0 {
+		t.Fatalf("Zip result: %+v, error: %+v", result, err)
+	}
+
+	unzipDir := t.TempDir()
+	result, err = Unzip(zipFilePath, unzipDir, 0755, &UnzipOptions{Verify: true})
+	if err != nil || len(result.Paths) != len(testFilePaths) {
+		t.Fatalf("Unzip result: %+v, error: %+v", result, err)
+	}
+	for _, tp := range testFilePaths {
+		testInputHash, _ := cryptoh.Sha256FileHash(tp)
+		outputFileHash, _ := cryptoh.Sha256FileHash(filepath.Join(unzipDir, filepath.Base(tp)))
+		if !bytes.Equal(testInputHash, outputFileHash) {
+			t.Error("input and output hashes are not equal.")
+		}
+	}
+
+	result, err = Zip(zipFilePath, []string{filepath.Join(t.TempDir(), "missing")}, nil, nil)
+	var ee *EntryError
+	if !errors.As(err, &ee) || len(result.Errors) != 1 {
+		t.Errorf("Zip of missing path, result: %+v, error: %+v", result, err)
+	}
+}
+
+func TestUnzipEntryErrors(t *testing.T) {
+	result, err := Unzip(filepath.Join("testdata", "aes256.zip"), t.TempDir(), 0755, &UnzipOptions{Password: "wrong"})
+	if err == nil || len(result.Errors) != 2 || len(result.Paths) != 3 {
+		t.Fatalf("Unzip result: %+v, error: %+v", result, err)
+	}
+	for _, err := range result.Errors {
+		var ee *EntryError
+		var pe *PasswordError
+		if !errors.As(err, &ee) || !errors.As(err, &pe) || ee.Path != pe.Name {
+			t.Errorf("error is not an EntryError wrapping a PasswordError: %+v", err)
+		}
+	}
+	var pe *PasswordError
+	if !errors.As(err, &pe) {
+		t.Errorf("joined error does not contain a PasswordError: %+v", err)
+	}
+
+	if _, err := Unzip(filepath.Join(t.TempDir(), "missing.zip"), t.TempDir(), 0755, nil); err == nil {
+		t.Errorf("Unzip of missing archive did not return an error")
+	}
+}
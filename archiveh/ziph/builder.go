@@ -0,0 +1,128 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Builder creates an archive from data that is in memory, or from an io.Reader, rather than
+// from files; I.E. generated reports or JSON. Entries are written in the order they are added.
+type Builder struct {
+	manifest  *Manifest
+	options   *ZipOptions
+	zipWriter *zip.Writer
+}
+
+// NewBuilder returns a Builder that writes an archive to w; a nil options uses the defaults.
+// All ZipOptions are supported, except ManifestSidecar and VolumeSize, which require a path.
+// In Deterministic mode entries are not sorted, so they must be added in a consistent order.
+// Close must be called to complete the archive.
+func NewBuilder(w io.Writer, options *ZipOptions) (*Builder, error) {
+	if options == nil {
+		options = &ZipOptions{}
+	}
+	if options.Deterministic && options.Password != "" {
+		return nil, fmt.Errorf("ziph: Deterministic is not supported with Password")
+	}
+	if options.Manifest == ManifestSidecar || options.VolumeSize > 0 {
+		return nil, fmt.Errorf("ziph: ManifestSidecar and VolumeSize are not supported by Builder")
+	}
+
+	b := Builder{options: options, zipWriter: zip.NewWriter(w)}
+	registerCompressors(b.zipWriter, options.CompressionLevel)
+	if options.Manifest == ManifestArchive {
+		b.manifest = &Manifest{Entries: []ManifestEntry{}}
+	}
+	return &b, nil
+}
+
+// AddBytes adds a file entry, with the name and data. The mode is the permission bits of
+// the file; zero uses 0644.
+func (b *Builder) AddBytes(name string, data []byte, mode fs.FileMode) error {
+	return b.add(name, bytes.NewReader(data), mode)
+}
+
+// AddDir adds a directory entry; directories do not need to be added for files in
+// them to be extracted, but do allow empty directories and directory permissions. The mode
+// is the permission bits of the directory; zero uses 0755.
+func (b *Builder) AddDir(name string, mode fs.FileMode) error {
+	if mode.Perm() == 0 {
+		mode |= 0755
+	}
+	header, err := b.header(name, fs.ModeDir|mode)
+	if err != nil {
+		return err
+	}
+	_, err = b.zipWriter.CreateHeader(header)
+	return err
+}
+
+// AddReader adds a file entry, with the name and data read from r until io.EOF. The mode
+// is the permission bits of the file; zero uses 0644. Use fs.ModeSymlink to add a symlink,
+// with the link target as the data. ZipOptions.ProbeCompressibility is only applied if r
+// is an io.ReadSeeker.
+func (b *Builder) AddReader(name string, r io.Reader, mode fs.FileMode) error {
+	return b.add(name, r, mode)
+}
+
+// Close writes the manifest, if requested, and completes the archive. The underlying
+// io.Writer is not closed.
+func (b *Builder) Close() error {
+	if b.manifest != nil {
+		if err := writeManifest(b.zipWriter, "", b.manifest, b.options); err != nil {
+			return err
+		}
+	}
+	return b.zipWriter.Close()
+}
+
+// add adds a file, or symlink, entry.
+func (b *Builder) add(name string, r io.Reader, mode fs.FileMode) error {
+	if mode.Perm() == 0 {
+		mode |= 0644
+	}
+	header, err := b.header(name, mode)
+	if err != nil {
+		return err
+	}
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		header.Method, err = entryMethod(header.Name, rs, b.options)
+	} else {
+		options := *b.options
+		options.ProbeCompressibility = false
+		header.Method, err = entryMethod(header.Name, nil, &options)
+	}
+	if err != nil {
+		return err
+	}
+
+	if mode&fs.ModeSymlink != 0 {
+		return writeEntry(b.zipWriter, header, r, b.options)
+	}
+	return writeFileEntry(b.zipWriter, header, r, b.options, b.manifest)
+}
+
+// header returns the header for an entry. Names must be valid per fs.ValidPath, with '/'
+// as the separator.
+func (b *Builder) header(name string, mode fs.FileMode) (*zip.FileHeader, error) {
+	name = strings.TrimSuffix(name, "/")
+	if !fs.ValidPath(name) || name == "." {
+		return nil, fmt.Errorf("ziph: invalid entry name: %s", name)
+	}
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+	header.SetMode(mode)
+	if mode.IsDir() {
+		header.Name += "/"
+	}
+	if b.options.Deterministic {
+		normalizeHeader(header, mode, b.options)
+	}
+	return header, nil
+}
@@ -0,0 +1,82 @@
+package ziph
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	buf := bytes.Buffer{}
+	b, err := NewBuilder(&buf, &ZipOptions{Manifest: ManifestArchive, Password: "pw"})
+	if err != nil {
+		t.Fatalf("NewBuilder error: %+v", err)
+	}
+	if err := b.AddDir("reports/empty", 0700); err != nil {
+		t.Errorf("AddDir error: %+v", err)
+	}
+	if err := b.AddBytes("reports/report.json", []byte(`{"ok":true}`), 0); err != nil {
+		t.Errorf("AddBytes error: %+v", err)
+	}
+	if err := b.AddReader("notes.txt", strings.NewReader("generated notes"), 0600); err != nil {
+		t.Errorf("AddReader error: %+v", err)
+	}
+	for _, name := range []string{"", "/abs.txt", "../up.txt", "a/./b.txt"} {
+		if err := b.AddBytes(name, nil, 0); err == nil {
+			t.Errorf("AddBytes did not reject name: %s", name)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+
+	afs, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "pw")
+	if err != nil {
+		t.Fatalf("NewFS error: %+v", err)
+	}
+	for name, want := range map[string]string{"reports/report.json": `{"ok":true}`, "notes.txt": "generated notes"} {
+		got, err := fs.ReadFile(afs, name)
+		if err != nil || string(got) != want {
+			t.Errorf("ReadFile name: %s, got: %s, error: %+v", name, got, err)
+		}
+	}
+	info, err := fs.Stat(afs, "notes.txt")
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Stat info: %+v, error: %+v", info, err)
+	}
+	info, err = fs.Stat(afs, "reports/empty")
+	if err != nil || !info.IsDir() {
+		t.Errorf("Stat info: %+v, error: %+v", info, err)
+	}
+
+	manifest, err := fs.ReadFile(afs, ManifestName)
+	if err != nil || !strings.Contains(string(manifest), "reports/report.json") {
+		t.Errorf("manifest: %s, error: %+v", manifest, err)
+	}
+}
+
+func TestBuilderDeterministic(t *testing.T) {
+	archives := [][]byte{}
+	for i := 0; i < 2; i++ {
+		buf := bytes.Buffer{}
+		b, err := NewBuilder(&buf, &ZipOptions{Deterministic: true})
+		if err != nil {
+			t.Fatalf("NewBuilder error: %+v", err)
+		}
+		if err := b.AddBytes("a.txt", []byte("a"), 0600+fs.FileMode(i)*0044); err != nil {
+			t.Errorf("AddBytes error: %+v", err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatalf("Close error: %+v", err)
+		}
+		archives = append(archives, buf.Bytes())
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Errorf("archives are not identical")
+	}
+
+	if _, err := NewBuilder(&bytes.Buffer{}, &ZipOptions{Manifest: ManifestSidecar}); err == nil {
+		t.Errorf("NewBuilder did not reject ManifestSidecar")
+	}
+}
@@ -0,0 +1,160 @@
+package ziph
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// probeSize is the number of bytes compressed by the compressibility probe.
+	probeSize = 64 * 1024
+	// probeRatio is the compressed/uncompressed ratio above which the probe stores an entry.
+	probeRatio = 0.9
+)
+
+// Compressor returns a new compressing writer, at the compression level, that writes to w.
+// The meaning of level is specific to the compressor; zero requests the default level.
+type Compressor func(w io.Writer, level int) (io.WriteCloser, error)
+
+var (
+	// DefaultStoreExtensions are extensions of file types that are already compressed; use
+	// with ZipOptions.StoreExtensions.
+	DefaultStoreExtensions = []string{".7z", ".avi", ".br", ".bz2", ".gif", ".gz", ".heic", ".jar", ".jpeg",
+		".jpg", ".lz4", ".mkv", ".mov", ".mp3", ".mp4", ".ogg", ".png", ".rar", ".tgz", ".webm", ".webp", ".xz",
+		".zip", ".zst"}
+
+	compressors = map[uint16]Compressor{
+		zip.Store: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		zip.Deflate: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = flate.DefaultCompression
+			}
+			return flate.NewWriter(w, level)
+		},
+	}
+	decompressors = map[uint16]zip.Decompressor{
+		zip.Store:   io.NopCloser,
+		zip.Deflate: flate.NewReader,
+	}
+	registryMutex sync.RWMutex
+)
+
+type countWriter struct {
+	count int64
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.count += int64(len(p))
+	return len(p), nil
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// RegisterCompressor registers a compressor and decompressor for a compression method, for
+// example zstd (93), for use by this package. Entries are compressed with the method by
+// setting ZipOptions.Method. An error is returned if the method is already registered.
+func RegisterCompressor(method uint16, comp Compressor, decomp zip.Decompressor) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := compressors[method]; ok {
+		return fmt.Errorf("ziph: compression method already registered: %d", method)
+	}
+	compressors[method] = comp
+	decompressors[method] = decomp
+	return nil
+}
+
+// compressor returns the registered compressor for method, at the compression level.
+func compressor(method uint16, level int) (zip.Compressor, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	comp, ok := compressors[method]
+	if !ok {
+		return nil, zip.ErrAlgorithm
+	}
+	return func(w io.Writer) (io.WriteCloser, error) { return comp(w, level) }, nil
+}
+
+// decompressor returns the registered decompressor for method.
+func decompressor(method uint16) (zip.Decompressor, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	decomp, ok := decompressors[method]
+	if !ok {
+		return nil, zip.ErrAlgorithm
+	}
+	return decomp, nil
+}
+
+// entryMethod returns the compression method for an entry: zip.Store if the name matches
+// options.StoreExtensions, or if options.ProbeCompressibility is set and the start of the
+// data does not compress; otherwise options.Method. The data is read from rs, which is
+// returned to the start.
+func entryMethod(name string, rs io.ReadSeeker, options *ZipOptions) (uint16, error) {
+	method := options.Method
+	if method == zip.Store {
+		method = zip.Deflate
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, storeExt := range options.StoreExtensions {
+		if ext != "" && ext == strings.ToLower(storeExt) {
+			return zip.Store, nil
+		}
+	}
+	if !options.ProbeCompressibility {
+		return method, nil
+	}
+
+	cw := &countWriter{}
+	fw, err := flate.NewWriter(cw, flate.BestSpeed)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(fw, io.LimitReader(rs, probeSize))
+	if err != nil {
+		return 0, err
+	}
+	if err := fw.Close(); err != nil {
+		return 0, err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if n == 0 || float64(cw.count) > probeRatio*float64(n) {
+		return zip.Store, nil
+	}
+	return method, nil
+}
+
+// registerCompressors registers all compressors, at the compression level, with zipWriter.
+func registerCompressors(zipWriter *zip.Writer, level int) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for method, comp := range compressors {
+		comp := comp
+		zipWriter.RegisterCompressor(method, func(w io.Writer) (io.WriteCloser, error) { return comp(w, level) })
+	}
+}
+
+// registerDecompressors registers all decompressors with zipReader.
+func registerDecompressors(zipReader *zip.Reader) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	for method, decomp := range decompressors {
+		zipReader.RegisterDecompressor(method, decomp)
+	}
+}
@@ -0,0 +1,143 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/lzw"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulfdunn/go-helper/cryptoh/v2"
+)
+
+// testMethodLZW is a compression method used only for testing RegisterCompressor.
+const testMethodLZW = 0xF0F0
+
+func TestCompressionLevel(t *testing.T) {
+	dir := t.TempDir()
+	text := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. 0123456789\n"), 20000)
+	if err := os.WriteFile(filepath.Join(dir, "text.txt"), text, 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+
+	sizes := map[int]uint64{}
+	for _, level := range []int{flate.BestSpeed, flate.BestCompression} {
+		zipFilePath := filepath.Join(t.TempDir(), "test_level.zip")
+		_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, []string{filepath.Join(dir, "text.txt")},
+			[]string{dir}, &ZipOptions{CompressionLevel: level})
+		if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+			t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+		}
+		methods := archiveEntries(t, zipFilePath)
+		sizes[level] = methods["text.txt"].CompressedSize64
+	}
+	if sizes[flate.BestCompression] >= sizes[flate.BestSpeed] {
+		t.Errorf("BestCompression was not smaller than BestSpeed: %+v", sizes)
+	}
+}
+
+func TestCompressionStore(t *testing.T) {
+	dir := t.TempDir()
+	random := make([]byte, 100000)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("rand.Read error: %+v", err)
+	}
+	files := map[string][]byte{
+		"photo.JPG":  bytes.Repeat([]byte("compressible"), 1000),
+		"random.bin": random,
+		"text.txt":   bytes.Repeat([]byte("compressible"), 1000),
+	}
+	paths := []string{}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatalf("WriteFile error: %+v", err)
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_store.zip")
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, paths, []string{dir},
+		&ZipOptions{ProbeCompressibility: true, StoreExtensions: DefaultStoreExtensions})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+
+	methods := archiveEntries(t, zipFilePath)
+	if methods["photo.JPG"].Method != zip.Store || methods["random.bin"].Method != zip.Store ||
+		methods["text.txt"].Method != zip.Deflate {
+		t.Errorf("incorrect methods, photo.JPG: %d, random.bin: %d, text.txt: %d",
+			methods["photo.JPG"].Method, methods["random.bin"].Method, methods["text.txt"].Method)
+	}
+
+	unzipDir := t.TempDir()
+	_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, len(paths), 0755,
+		&UnzipOptions{Verify: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions errors: %+v", result.Errors)
+	}
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	err := RegisterCompressor(testMethodLZW,
+		func(w io.Writer, level int) (io.WriteCloser, error) { return lzw.NewWriter(w, lzw.LSB, 8), nil },
+		func(r io.Reader) io.ReadCloser { return lzw.NewReader(r, lzw.LSB, 8) })
+	if err != nil {
+		t.Fatalf("RegisterCompressor error: %+v", err)
+	}
+	if err := RegisterCompressor(zip.Deflate, nil, nil); err == nil {
+		t.Errorf("RegisterCompressor did not return an error for a registered method")
+	}
+
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+	trim := filepath.Dir(testFilePaths[0])
+	for _, password := range []string{"", "pw"} {
+		zipFilePath := filepath.Join(t.TempDir(), "test_lzw.zip")
+		_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, testFilePaths, []string{trim},
+			&ZipOptions{Method: testMethodLZW, Password: password})
+		if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+			t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+		}
+		if password == "" {
+			for name, f := range archiveEntries(t, zipFilePath) {
+				if f.Method != testMethodLZW {
+					t.Errorf("%s method: %d", name, f.Method)
+				}
+			}
+		}
+
+		unzipDir := t.TempDir()
+		_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, len(testFilePaths), 0755,
+			&UnzipOptions{Password: password, Verify: true})
+		if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+			t.Fatalf("AsyncUnzipWithOptions errors: %+v", result.Errors)
+		}
+		for _, tp := range testFilePaths {
+			testInputHash, _ := cryptoh.Sha256FileHash(tp)
+			outputFileHash, _ := cryptoh.Sha256FileHash(filepath.Join(unzipDir, filepath.Base(tp)))
+			if !bytes.Equal(testInputHash, outputFileHash) {
+				t.Error("input and output hashes are not equal.")
+			}
+		}
+	}
+}
+
+// archiveEntries returns the archive entries by name.
+func archiveEntries(t *testing.T, zipFilePath string) map[string]*zip.File {
+	zr, err := zip.OpenReader(zipFilePath)
+	if err != nil {
+		t.Fatalf("zip.OpenReader error: %+v", err)
+	}
+	defer zr.Close()
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	return files
+}
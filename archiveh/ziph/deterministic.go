@@ -0,0 +1,69 @@
+package ziph
+
+import (
+	"archive/zip"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultModTime is the modification time of entries created in Deterministic mode when
+// ZipOptions.ModTime is not set; it is the earliest time an MS-DOS timestamp can hold.
+var DefaultModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// collectZipEntries is a closure called by AsyncZipWithOptions, in Deterministic mode, to
+// collect the zipEntry for each walked path; do not directly call this function.
+func collectZipEntries(entries *[]*zipEntry, trimFilepath []string, options *ZipOptions,
+	errors chan error) func(string, fs.DirEntry, error) error {
+	return func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			errors <- &EntryError{Path: path, Err: err}
+			return fs.SkipDir
+		}
+
+		ze, err := newZipEntry(path, dirEntry, trimFilepath, options)
+		if err != nil {
+			return &EntryError{Path: path, Err: err}
+		}
+		*entries = append(*entries, ze)
+		return nil
+	}
+}
+
+// normalizeHeader removes everything from header that varies by host or by time: the name
+// uses '/' as the separator, the mode is normalized, the modification time is set to
+// options.ModTime, and extra fields are removed.
+func normalizeHeader(header *zip.FileHeader, mode fs.FileMode, options *ZipOptions) {
+	header.Name = filepath.ToSlash(header.Name)
+
+	switch {
+	case mode.IsDir():
+		mode = fs.ModeDir | 0755
+	case mode&fs.ModeSymlink != 0:
+		mode = fs.ModeSymlink | 0777
+	case mode&0111 != 0:
+		mode = 0755
+	default:
+		mode = 0644
+	}
+	header.SetMode(mode)
+
+	modTime := options.ModTime
+	if modTime.IsZero() {
+		modTime = DefaultModTime
+	}
+	// With Modified zero, zip.Writer uses the MS-DOS fields and does not add an extended
+	// timestamp extra field.
+	header.Modified = time.Time{}
+	header.ModifiedDate, header.ModifiedTime = timeToMsDosTime(modTime)
+	header.Extra = nil
+}
+
+// sortZipEntries sorts entries by name. The sort is stable so duplicate names keep the
+// order in which they were walked.
+func sortZipEntries(entries []*zipEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].header.Name < entries[j].header.Name
+	})
+}
@@ -0,0 +1,80 @@
+package ziph
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestDeterministic creates the same content in two directories, with different modification
+// times, permissions, and creation order, and checks the archives are byte identical.
+func TestDeterministic(t *testing.T) {
+	modTime := time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC)
+	zipFilePaths := []string{}
+	for i, order := range [][]string{{"a.txt", "b", "c.txt"}, {"c.txt", "b", "a.txt"}} {
+		dir := t.TempDir()
+		paths := []string{}
+		for _, name := range order {
+			path := filepath.Join(dir, name)
+			paths = append(paths, path)
+			if name == "b" {
+				if err := os.MkdirAll(filepath.Join(path, "sub"), 0700+os.FileMode(i)*0055); err != nil {
+					t.Fatalf("MkdirAll error: %+v", err)
+				}
+				path = filepath.Join(path, "sub", "b.txt")
+			}
+			if err := os.WriteFile(path, bytes.Repeat([]byte(name), 1000), 0600+os.FileMode(i)*0044); err != nil {
+				t.Fatalf("WriteFile error: %+v", err)
+			}
+			mtime := time.Now().Add(-time.Duration(i) * time.Hour)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatalf("Chtimes error: %+v", err)
+			}
+		}
+
+		zipFilePath := filepath.Join(t.TempDir(), "test_deterministic.zip")
+		_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, paths, []string{dir},
+			&ZipOptions{Deterministic: true, Manifest: ManifestArchive, ModTime: modTime, PreserveMetadata: true})
+		if result, _ := collectResult(processedPaths, errs); len(result.Paths) != len(paths) || len(result.Errors) != 0 {
+			t.Fatalf("AsyncZipWithOptions paths: %d, errors: %+v", len(result.Paths), result.Errors)
+		}
+		zipFilePaths = append(zipFilePaths, zipFilePath)
+	}
+
+	zip0, err := os.ReadFile(zipFilePaths[0])
+	if err != nil {
+		t.Fatalf("ReadFile error: %+v", err)
+	}
+	zip1, err := os.ReadFile(zipFilePaths[1])
+	if err != nil {
+		t.Fatalf("ReadFile error: %+v", err)
+	}
+	if !bytes.Equal(zip0, zip1) {
+		t.Errorf("archives are not identical")
+	}
+
+	names := []string{}
+	for name, f := range archiveEntries(t, zipFilePaths[0]) {
+		names = append(names, name)
+		if !f.Modified.Equal(modTime) || len(f.Extra) != 0 {
+			t.Errorf("%s modified: %v, extra: %v", name, f.Modified, f.Extra)
+		}
+		if (f.Mode().IsDir() && f.Mode().Perm() != 0755) || (f.Mode().IsRegular() && f.Mode().Perm() != 0644) {
+			t.Errorf("%s mode: %v", name, f.Mode())
+		}
+	}
+	if len(names) != 6 {
+		t.Errorf("expected 6 entries, got: %v", names)
+	}
+}
+
+func TestDeterministicPassword(t *testing.T) {
+	zipFilePath := filepath.Join(t.TempDir(), "test_deterministic.zip")
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, []string{"."}, nil,
+		&ZipOptions{Deterministic: true, Password: "pw"})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 1 {
+		t.Errorf("expected 1 error, got: %+v", result.Errors)
+	}
+}
@@ -0,0 +1,296 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/paulfdunn/go-helper/cryptoh/v2"
+)
+
+// DiffKind is a set of differences between an archive entry and a path in a directory.
+type DiffKind uint8
+
+const (
+	// DiffAdded is an entry in the archive that is not in the directory.
+	DiffAdded DiffKind = 1 << iota
+	// DiffRemoved is a path in the directory that is not in the archive.
+	DiffRemoved
+	// DiffModified is an entry that differs from the path in type, size, CRC32, link
+	// target, or, for AE-2 encrypted entries that have no CRC32, SHA-256.
+	DiffModified
+	// DiffModeChanged is an entry that differs from the path in permission bits. Modes are
+	// only compared for entries created on Unix, as other archivers do not store them.
+	DiffModeChanged
+)
+
+// creatorUnix and creatorMacOSX are the upper byte of zip.FileHeader.CreatorVersion for
+// archives that store Unix modes.
+const (
+	creatorUnix   = 3
+	creatorMacOSX = 19
+)
+
+// DiffEntry is a difference between an archive and a directory.
+type DiffEntry struct {
+	Kind DiffKind
+	// Name is the entry name, or the path relative to the directory, with '/' as the separator
+	// and no trailing separator for directories.
+	Name string
+}
+
+// DiffOptions are optional settings for DiffDir.
+type DiffOptions struct {
+	// Apply makes the directory match the archive: added and modified entries are
+	// extracted, with Verify and RestoreMetadata, and the mode is set for mode changed entries.
+	// Removed paths are only deleted if Remove is also set.
+	Apply bool
+	// Password is used to decrypt WinZip AES encrypted entries.
+	Password string
+	// Remove deletes removed paths when Apply is set.
+	Remove bool
+}
+
+func (dk DiffKind) String() string {
+	kinds := []string{}
+	for i, name := range []string{"added", "removed", "modified", "mode changed"} {
+		if dk&(1<<i) != 0 {
+			kinds = append(kinds, name)
+		}
+	}
+	return strings.Join(kinds, "|")
+}
+
+// DiffDir compares the archive, or split archive, at zipPath to the directory at dirPath and
+// returns the differences, sorted by name. Directories that are implied by entry names are
+// not reported as removed. A nil options uses the defaults. When options.Apply is set,
+// the differences are applied to dirPath and the returned error joins any errors applying
+// them; an *EntryError for each entry.
+func DiffDir(zipPath string, dirPath string, options *DiffOptions) ([]DiffEntry, error) {
+	if options == nil {
+		options = &DiffOptions{}
+	}
+	zr, err := openReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := zr.Close(); err != nil {
+			fmt.Printf("defer zr.Close() error:%+v\n", err)
+		}
+	}()
+	dirPath, err = filepath.Abs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	// names is every entry name and every directory implied by them.
+	names := map[string]bool{}
+	diffs := []DiffEntry{}
+	for _, f := range zr.File {
+		if !validOutputPath(dirPath, f.Name) {
+			return nil, fmt.Errorf("DiffDir invalid file path: %s", f.Name)
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(f.Name), "/")
+		files[name] = f
+		for n := name; n != "." && !names[n]; n = path.Dir(n) {
+			names[n] = true
+		}
+
+		kind, err := diffEntry(f, filepath.Join(dirPath, filepath.FromSlash(name)), options.Password)
+		if err != nil {
+			return nil, &EntryError{Path: f.Name, Err: err}
+		}
+		if kind != 0 {
+			diffs = append(diffs, DiffEntry{Kind: kind, Name: name})
+		}
+	}
+
+	err = filepath.WalkDir(dirPath, func(walkPath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dirPath, walkPath)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if names[rel] {
+			return nil
+		}
+		diffs = append(diffs, DiffEntry{Kind: DiffRemoved, Name: rel})
+		if dirEntry.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+
+	if !options.Apply {
+		return diffs, nil
+	}
+	return diffs, applyDiff(diffs, files, dirPath, options)
+}
+
+// applyDiff applies diffs, from DiffDir, to dirPath. Removed paths are deleted first, deepest
+// first, so a directory that is replaced by a file is empty when it is removed.
+func applyDiff(diffs []DiffEntry, files map[string]*zip.File, dirPath string, options *DiffOptions) error {
+	unzipOptions := &UnzipOptions{Password: options.Password, RestoreMetadata: true, Verify: true}
+	errs := []error{}
+	if options.Remove {
+		for i := len(diffs) - 1; i >= 0; i-- {
+			if diffs[i].Kind != DiffRemoved {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(dirPath, filepath.FromSlash(diffs[i].Name))); err != nil {
+				errs = append(errs, &EntryError{Path: diffs[i].Name, Err: err})
+			}
+		}
+	}
+
+	dirs := []*zip.File{}
+	for _, diff := range diffs {
+		f := files[diff.Name]
+		outputFilePath := filepath.Join(dirPath, filepath.FromSlash(diff.Name))
+		var err error
+		switch {
+		case diff.Kind&(DiffAdded|DiffModified) != 0:
+			// A path of a different type must be removed first; a non empty directory is not removed.
+			if info, lerr := os.Lstat(outputFilePath); lerr == nil && info.IsDir() != f.FileInfo().IsDir() {
+				err = os.Remove(outputFilePath)
+			}
+			if err == nil {
+				err = removeFromZip(f, dirPath, 0755, unzipOptions)
+			}
+			if err == nil && f.FileInfo().IsDir() {
+				dirs = append(dirs, f)
+			}
+		case diff.Kind&DiffModeChanged != 0:
+			err = os.Chmod(outputFilePath, f.Mode().Perm())
+		}
+		if err != nil {
+			errs = append(errs, &EntryError{Path: f.Name, Err: err})
+		}
+	}
+
+	// removeFromZip creates directories with mode 0755, so directory metadata is restored
+	// last, and deepest first, as in AsyncUnzipWithOptions.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMetadata(dirs[i], filepath.Join(dirPath, filepath.FromSlash(dirs[i].Name))); err != nil {
+			errs = append(errs, &EntryError{Path: dirs[i].Name, Err: err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// diffEntry compares zipFile to the path, which is where it would be extracted.
+func diffEntry(zipFile *zip.File, path string, password string) (DiffKind, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DiffAdded, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	mode := zipFile.Mode()
+	if mode.Type() != info.Mode().Type() {
+		return DiffModified, nil
+	}
+	kind := DiffKind(0)
+	creator := zipFile.CreatorVersion >> 8
+	if (creator == creatorUnix || creator == creatorMacOSX) && mode&fs.ModeSymlink == 0 &&
+		mode.Perm() != info.Mode().Perm() {
+		kind |= DiffModeChanged
+	}
+
+	switch {
+	case mode.IsDir():
+		return kind, nil
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return 0, err
+		}
+		data, err := entryData(zipFile, password, symlinkMaxLen)
+		if err != nil {
+			return 0, err
+		}
+		if string(data) != target {
+			kind |= DiffModified
+		}
+		return kind, nil
+	}
+
+	if uint64(info.Size()) != zipFile.UncompressedSize64 {
+		return kind | DiffModified, nil
+	}
+	if isAES(zipFile) && zipFile.CRC32 == 0 {
+		same, err := sameSHA256(zipFile, path, password)
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			kind |= DiffModified
+		}
+		return kind, nil
+	}
+	sum, err := fileCRC32(path)
+	if err != nil {
+		return 0, err
+	}
+	if sum != zipFile.CRC32 {
+		kind |= DiffModified
+	}
+	return kind, nil
+}
+
+// entryData reads at most maxLen bytes of the content of zipFile.
+func entryData(zipFile *zip.File, password string, maxLen int64) ([]byte, error) {
+	rc, err := openEntry(zipFile, password)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			fmt.Printf("defer rc.Close() error:%+v\n", err)
+		}
+	}()
+	return io.ReadAll(io.LimitReader(rc, maxLen))
+}
+
+// sameSHA256 returns true if the content of zipFile and the file at path have the same SHA-256.
+func sameSHA256(zipFile *zip.File, path string, password string) (bool, error) {
+	rc, err := openEntry(zipFile, password)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			fmt.Printf("defer rc.Close() error:%+v\n", err)
+		}
+	}()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return false, err
+	}
+	fileHash, err := cryptoh.Sha256FileHash(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), fileHash), nil
+}
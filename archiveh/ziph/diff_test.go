@@ -0,0 +1,135 @@
+package ziph
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDiffDir(t *testing.T) {
+	zipFilePath := filepath.Join(t.TempDir(), "test_diff.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	b, err := NewBuilder(f, nil)
+	if err != nil {
+		t.Fatalf("NewBuilder error: %+v", err)
+	}
+	if err := b.AddDir("d", 0755); err != nil {
+		t.Fatalf("AddDir error: %+v", err)
+	}
+	for _, name := range []string{"d/a.txt", "b.txt", "c.txt"} {
+		if err := b.AddBytes(name, []byte(name), 0644); err != nil {
+			t.Fatalf("AddBytes error: %+v", err)
+		}
+	}
+	if err := b.AddBytes("e.txt", []byte("e.txt"), 0600); err != nil {
+		t.Fatalf("AddBytes error: %+v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	f.Close()
+
+	dir := t.TempDir()
+	if _, err := Unzip(zipFilePath, dir, 0755, &UnzipOptions{RestoreMetadata: true}); err != nil {
+		t.Fatalf("Unzip error: %+v", err)
+	}
+	if diffs, err := DiffDir(zipFilePath, dir, nil); err != nil || len(diffs) != 0 {
+		t.Fatalf("DiffDir of extracted archive, diffs: %+v, error: %+v", diffs, err)
+	}
+
+	// Same size as the entry, but different content.
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("B.TXT"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "c.txt")); err != nil {
+		t.Fatalf("Remove error: %+v", err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "e.txt"), 0644); err != nil {
+		t.Fatalf("Chmod error: %+v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "x.txt"), []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "y"), 0755); err != nil {
+		t.Fatalf("MkdirAll error: %+v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "y", "z.txt"), []byte("z"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+
+	want := []DiffEntry{
+		{Kind: DiffModified, Name: "b.txt"},
+		{Kind: DiffAdded, Name: "c.txt"},
+		{Kind: DiffModeChanged, Name: "e.txt"},
+		{Kind: DiffRemoved, Name: "x.txt"},
+		{Kind: DiffRemoved, Name: "y"},
+	}
+	diffs, err := DiffDir(zipFilePath, dir, nil)
+	if err != nil || !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("DiffDir diffs: %+v, want: %+v, error: %+v", diffs, want, err)
+	}
+
+	// Apply without Remove leaves the removed paths.
+	if diffs, err = DiffDir(zipFilePath, dir, &DiffOptions{Apply: true}); err != nil || !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("DiffDir Apply diffs: %+v, error: %+v", diffs, err)
+	}
+	if diffs, err = DiffDir(zipFilePath, dir, nil); err != nil || !reflect.DeepEqual(diffs, want[3:]) {
+		t.Fatalf("DiffDir after Apply diffs: %+v, error: %+v", diffs, err)
+	}
+	if _, err = DiffDir(zipFilePath, dir, &DiffOptions{Apply: true, Remove: true}); err != nil {
+		t.Fatalf("DiffDir Apply Remove error: %+v", err)
+	}
+	if diffs, err = DiffDir(zipFilePath, dir, nil); err != nil || len(diffs) != 0 {
+		t.Errorf("DiffDir after Apply Remove diffs: %+v, error: %+v", diffs, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "b.txt")); err != nil || string(b) != "b.txt" {
+		t.Errorf("b.txt: %s, error: %+v", b, err)
+	}
+}
+
+// TestDiffDirApplyDirMode checks that Apply restores the mode of added directories, so a
+// second DiffDir finds no differences.
+func TestDiffDirApplyDirMode(t *testing.T) {
+	zipFilePath := filepath.Join(t.TempDir(), "test_diff_dir_mode.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	b, err := NewBuilder(f, nil)
+	if err != nil {
+		t.Fatalf("NewBuilder error: %+v", err)
+	}
+	for _, name := range []string{"d", "d/e"} {
+		if err := b.AddDir(name, 0700); err != nil {
+			t.Fatalf("AddDir error: %+v", err)
+		}
+	}
+	if err := b.AddBytes("d/e/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatalf("AddBytes error: %+v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	f.Close()
+
+	dir := t.TempDir()
+	if _, err := DiffDir(zipFilePath, dir, &DiffOptions{Apply: true}); err != nil {
+		t.Fatalf("DiffDir Apply error: %+v", err)
+	}
+	if diffs, err := DiffDir(zipFilePath, dir, nil); err != nil || len(diffs) != 0 {
+		t.Errorf("DiffDir after Apply diffs: %+v, error: %+v", diffs, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "d", "e")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("directory mode: %v, error: %+v", info.Mode(), err)
+	}
+}
+
+func TestDiffKindString(t *testing.T) {
+	if s := (DiffModified | DiffModeChanged).String(); s != "modified|mode changed" {
+		t.Errorf("String: %s", s)
+	}
+}
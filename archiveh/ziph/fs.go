@@ -0,0 +1,148 @@
+package ziph
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// ArchiveFS is a read only fs.FS, and fs.ReadDirFS, view of an archive; I.E. for use with
+// html/template.ParseFS or http.FileServer(http.FS(archiveFS)). Encrypted entries are
+// decrypted with the password provided when the ArchiveFS was created. Files implement
+// io.Seeker; seeking backwards re-reads the entry from the start.
+type ArchiveFS struct {
+	closer   io.Closer
+	files    map[string]*zip.File
+	password string
+	zr       *zip.Reader
+}
+
+// archiveFile is an open file in an ArchiveFS.
+type archiveFile struct {
+	file     *zip.File
+	offset   int64
+	password string
+	rc       io.ReadCloser
+	rcOffset int64
+}
+
+// NewFS returns an ArchiveFS for the archive read from r, which is size bytes; I.E. an
+// archive in memory from a Builder, using bytes.NewReader.
+func NewFS(r io.ReaderAt, size int64, password string) (*ArchiveFS, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	registerDecompressors(zr)
+	return newArchiveFS(zr, nil, password), nil
+}
+
+// OpenFS returns an ArchiveFS for the archive, or split archive, at zipPath. Close must be
+// called when the ArchiveFS is no longer needed.
+func OpenFS(zipPath string, password string) (*ArchiveFS, error) {
+	rc, err := openReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	return newArchiveFS(rc.Reader, rc, password), nil
+}
+
+// Close closes the archive, if it was opened with OpenFS.
+func (afs *ArchiveFS) Close() error {
+	if afs.closer == nil {
+		return nil
+	}
+	return afs.closer.Close()
+}
+
+// Open implements fs.FS.
+func (afs *ArchiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if f, ok := afs.files[name]; ok {
+		return &archiveFile{file: f, password: afs.password}, nil
+	}
+	// Directories, including those that are implied by file names, are provided by zip.Reader.
+	return afs.zr.Open(name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (afs *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(afs.zr, name)
+}
+
+func (af *archiveFile) Close() error {
+	if af.rc == nil {
+		return nil
+	}
+	err := af.rc.Close()
+	af.rc = nil
+	return err
+}
+
+// Read reads from the open entry, until it returns io.EOF, so that encrypted entries are
+// authenticated; Close authenticates entries that are not read to the end.
+func (af *archiveFile) Read(p []byte) (int, error) {
+	if af.rc == nil && af.offset >= int64(af.file.UncompressedSize64) {
+		return 0, io.EOF
+	}
+	if af.rc == nil || af.rcOffset > af.offset {
+		if err := af.Close(); err != nil {
+			return 0, err
+		}
+		rc, err := openEntry(af.file, af.password)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: af.file.Name, Err: err}
+		}
+		af.rc = rc
+		af.rcOffset = 0
+	}
+	if af.rcOffset < af.offset {
+		n, err := io.CopyN(io.Discard, af.rc, af.offset-af.rcOffset)
+		af.rcOffset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := af.rc.Read(p)
+	af.offset += int64(n)
+	af.rcOffset += int64(n)
+	return n, err
+}
+
+func (af *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += af.offset
+	case io.SeekEnd:
+		offset += int64(af.file.UncompressedSize64)
+	default:
+		return 0, errors.New("ziph: invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("ziph: negative position: %d", offset)
+	}
+	af.offset = offset
+	return offset, nil
+}
+
+func (af *archiveFile) Stat() (fs.FileInfo, error) {
+	return af.file.FileInfo(), nil
+}
+
+// newArchiveFS creates an ArchiveFS from a zip.Reader; file entries are indexed so they
+// can be opened, and decrypted, by this package rather than by zip.Reader.
+func newArchiveFS(zr *zip.Reader, closer io.Closer, password string) *ArchiveFS {
+	afs := ArchiveFS{closer: closer, files: make(map[string]*zip.File), password: password, zr: zr}
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			afs.files[f.Name] = f
+		}
+	}
+	return &afs
+}
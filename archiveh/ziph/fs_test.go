@@ -0,0 +1,116 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestArchiveFS(t *testing.T) {
+	for _, password := range []string{"", "pw"} {
+		buf := bytes.Buffer{}
+		b, err := NewBuilder(&buf, &ZipOptions{Password: password})
+		if err != nil {
+			t.Fatalf("NewBuilder error: %+v", err)
+		}
+		files := map[string][]byte{
+			"index.html":       []byte("<html>index</html>"),
+			"static/app.js":    bytes.Repeat([]byte("console.log('app');\n"), 1000),
+			"static/css/a.css": []byte("body {}"),
+		}
+		for name, data := range files {
+			if err := b.AddBytes(name, data, 0); err != nil {
+				t.Fatalf("AddBytes error: %+v", err)
+			}
+		}
+		if err := b.Close(); err != nil {
+			t.Fatalf("Close error: %+v", err)
+		}
+
+		afs, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), password)
+		if err != nil {
+			t.Fatalf("NewFS error: %+v", err)
+		}
+		if err := fstest.TestFS(afs, "index.html", "static/app.js", "static/css/a.css"); err != nil {
+			t.Errorf("password: %s, fstest.TestFS error: %+v", password, err)
+		}
+
+		server := httptest.NewServer(http.FileServer(http.FS(afs)))
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/static/app.js", nil)
+		if err != nil {
+			t.Fatalf("NewRequest error: %+v", err)
+		}
+		req.Header.Set("Range", "bytes=20-39")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do error: %+v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+		if err != nil || resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, files["static/app.js"][20:40]) {
+			t.Errorf("password: %s, status: %d, body: %s, error: %+v", password, resp.StatusCode, body, err)
+		}
+	}
+}
+
+func TestOpenFS(t *testing.T) {
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+	zipFilePath := filepath.Join(t.TempDir(), "test_fs.zip")
+	trim := filepath.Dir(testFilePaths[0])
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, testFilePaths, []string{trim},
+		&ZipOptions{VolumeSize: 500000})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+
+	afs, err := OpenFS(zipFilePath, "")
+	if err != nil {
+		t.Fatalf("OpenFS error: %+v", err)
+	}
+	defer afs.Close()
+	entries, err := afs.ReadDir(".")
+	if err != nil || len(entries) != len(testFilePaths) {
+		t.Errorf("ReadDir entries: %+v, error: %+v", entries, err)
+	}
+	if err := fstest.TestFS(afs, filepath.Base(testFilePaths[0]), filepath.Base(testFilePaths[1])); err != nil {
+		t.Errorf("fstest.TestFS error: %+v", err)
+	}
+}
+
+// TestArchiveFSTampered checks that a changed authentication code is returned by reading
+// to the end, and by Close after reading the size of the file.
+func TestArchiveFSTampered(t *testing.T) {
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		afs, err := OpenFS(tamperedAESArchive(t, method, 1), "pa55word")
+		if err != nil {
+			t.Fatalf("OpenFS error: %+v", err)
+		}
+		if _, err := fs.ReadFile(afs, "a.txt"); err == nil {
+			t.Errorf("method: %d, ReadFile did not return an error", method)
+		}
+
+		f, err := afs.Open("a.txt")
+		if err != nil {
+			t.Fatalf("Open error: %+v", err)
+		}
+		info, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Stat error: %+v", err)
+		}
+		_, cerr := io.CopyN(io.Discard, f, info.Size())
+		if err := f.Close(); cerr == nil && err == nil {
+			t.Errorf("method: %d, CopyN and Close did not return an error", method)
+		}
+		afs.Close()
+	}
+}
@@ -0,0 +1,221 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/paulfdunn/go-helper/cryptoh/v2"
+)
+
+// ManifestMode specifies if, and where, AsyncZipWithOptions writes a manifest.
+type ManifestMode int
+
+const (
+	// ManifestNone does not write a manifest.
+	ManifestNone ManifestMode = iota
+	// ManifestArchive writes the manifest into the archive as an entry named ManifestName.
+	ManifestArchive
+	// ManifestSidecar writes the manifest next to the archive, at the zip path plus ManifestSidecarSuffix.
+	ManifestSidecar
+)
+
+const (
+	ManifestName          = "ziph_manifest.json"
+	ManifestSidecarSuffix = ".manifest.json"
+)
+
+// IntegrityError is returned when an extracted file, or archive entry, does not match
+// the archive or manifest.
+type IntegrityError struct {
+	Path   string
+	Reason string
+}
+
+// Manifest is a list of the files in an archive, with the size and SHA-256 of each.
+type Manifest struct {
+	Entries []ManifestEntry
+}
+
+// ManifestEntry is a single file in a Manifest; Path is the archive entry name.
+type ManifestEntry struct {
+	Path   string
+	SHA256 string
+	Size   int64
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("ziph: integrity check failed for: %s, %s", e.Path, e.Reason)
+}
+
+// ArchiveManifest reads the manifest stored in the archive at zipPath. The password is only
+// required if the archive is encrypted.
+func ArchiveManifest(zipPath string, password string) (*Manifest, error) {
+	zr, err := openReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := zr.Close(); err != nil {
+			fmt.Printf("defer zr.Close() error:%+v\n", err)
+		}
+	}()
+
+	for _, f := range zr.File {
+		if f.Name != ManifestName {
+			continue
+		}
+		rc, err := openEntry(f, password)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := rc.Close(); err != nil {
+				fmt.Printf("defer rc.Close() error:%+v\n", err)
+			}
+		}()
+		return decodeManifest(rc)
+	}
+	return nil, fmt.Errorf("ziph: archive has no manifest: %s", zipPath)
+}
+
+// LoadManifest reads a sidecar manifest, or a manifest extracted from an archive.
+func LoadManifest(manifestPath string) (*Manifest, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("defer f.Close() error:%+v\n", err)
+		}
+	}()
+	return decodeManifest(f)
+}
+
+// VerifyArchive checks every file in the manifest against the entries in the archive at
+// zipPath. The returned error joins an *IntegrityError for every file that is missing
+// or does not match, and is nil if all files match.
+func (m *Manifest) VerifyArchive(zipPath string, password string) error {
+	zr, err := openReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := zr.Close(); err != nil {
+			fmt.Printf("defer zr.Close() error:%+v\n", err)
+		}
+	}()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	errs := []error{}
+	for _, entry := range m.Entries {
+		f, ok := files[entry.Path]
+		if !ok {
+			errs = append(errs, &IntegrityError{Path: entry.Path, Reason: "missing from archive"})
+			continue
+		}
+		if err := entry.verifyEntry(f, password); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyDir checks every file in the manifest against the files extracted to dirPath.
+// Files in dirPath that are not in the manifest are ignored. The returned error joins an
+// *IntegrityError for every file that is missing or does not match, and is nil if all
+// files match.
+func (m *Manifest) VerifyDir(dirPath string) error {
+	errs := []error{}
+	for _, entry := range m.Entries {
+		filePath := filepath.Join(dirPath, filepath.FromSlash(entry.Path))
+		info, err := os.Stat(filePath)
+		if err != nil {
+			errs = append(errs, &IntegrityError{Path: entry.Path, Reason: err.Error()})
+			continue
+		}
+		if info.Size() != entry.Size {
+			errs = append(errs, &IntegrityError{Path: entry.Path,
+				Reason: fmt.Sprintf("size mismatch, manifest: %d, file: %d", entry.Size, info.Size())})
+			continue
+		}
+		hash, err := cryptoh.Sha256FileHash(filePath)
+		if err != nil {
+			errs = append(errs, &IntegrityError{Path: entry.Path, Reason: err.Error()})
+			continue
+		}
+		if hex.EncodeToString(hash) != entry.SHA256 {
+			errs = append(errs, &IntegrityError{Path: entry.Path, Reason: "sha256 mismatch"})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyEntry hashes the content of an archive entry and compares it to the manifest entry.
+func (entry ManifestEntry) verifyEntry(f *zip.File, password string) error {
+	rc, err := openEntry(f, password)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			fmt.Printf("defer rc.Close() error:%+v\n", err)
+		}
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return &IntegrityError{Path: entry.Path, Reason: err.Error()}
+	}
+	if n != entry.Size {
+		return &IntegrityError{Path: entry.Path,
+			Reason: fmt.Sprintf("size mismatch, manifest: %d, archive: %d", entry.Size, n)}
+	}
+	if hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+		return &IntegrityError{Path: entry.Path, Reason: "sha256 mismatch"}
+	}
+	return nil
+}
+
+func decodeManifest(r io.Reader) (*Manifest, error) {
+	m := Manifest{}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("ziph: decoding manifest, error: %w", err)
+	}
+	return &m, nil
+}
+
+// writeManifest writes the manifest into the archive, or to a sidecar file, per options.Manifest.
+func writeManifest(zipWriter *zip.Writer, zipPath string, manifest *Manifest, options *ZipOptions) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch options.Manifest {
+	case ManifestArchive:
+		header := &zip.FileHeader{Name: ManifestName, Method: zip.Deflate, Modified: time.Now()}
+		header.SetMode(0644)
+		if options.Deterministic {
+			normalizeHeader(header, 0644, options)
+		}
+		return writeEntry(zipWriter, header, bytes.NewReader(b), options)
+	case ManifestSidecar:
+		return os.WriteFile(zipPath+ManifestSidecarSuffix, b, 0644)
+	}
+	return fmt.Errorf("ziph: invalid manifest mode: %d", options.Manifest)
+}
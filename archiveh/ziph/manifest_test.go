@@ -0,0 +1,130 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestArchive(t *testing.T) {
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_manifest.zip")
+	trim := filepath.Dir(testFilePaths[0])
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, testFilePaths, []string{trim},
+		&ZipOptions{Manifest: ManifestArchive})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+
+	manifest, err := ArchiveManifest(zipFilePath, "")
+	if err != nil || len(manifest.Entries) != len(testFilePaths) {
+		t.Fatalf("ArchiveManifest manifest: %+v, error: %+v", manifest, err)
+	}
+	if err := manifest.VerifyArchive(zipFilePath, ""); err != nil {
+		t.Errorf("VerifyArchive error: %+v", err)
+	}
+
+	unzipDir := t.TempDir()
+	_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, len(testFilePaths)+1, 0755,
+		&UnzipOptions{Verify: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions errors: %+v", result.Errors)
+	}
+
+	// The manifest was extracted with the files.
+	manifest, err = LoadManifest(filepath.Join(unzipDir, ManifestName))
+	if err != nil {
+		t.Fatalf("LoadManifest error: %+v", err)
+	}
+	if err := manifest.VerifyDir(unzipDir); err != nil {
+		t.Errorf("VerifyDir error: %+v", err)
+	}
+
+	// Modify an extracted file and remove another.
+	if err := os.WriteFile(filepath.Join(unzipDir, manifest.Entries[0].Path), []byte("modified"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	if err := os.Remove(filepath.Join(unzipDir, manifest.Entries[1].Path)); err != nil {
+		t.Fatalf("Remove error: %+v", err)
+	}
+	err = manifest.VerifyDir(unzipDir)
+	var ie *IntegrityError
+	if !errors.As(err, &ie) || len(err.(interface{ Unwrap() []error }).Unwrap()) != 2 {
+		t.Errorf("VerifyDir expected 2 IntegrityError, got: %+v", err)
+	}
+}
+
+func TestManifestSidecar(t *testing.T) {
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_manifest.zip")
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, testFilePaths, nil,
+		&ZipOptions{Manifest: ManifestSidecar, Password: "pw"})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+
+	zs, err := GetZipStats(zipFilePath)
+	if err != nil || zs.FileCount != len(testFilePaths) {
+		t.Errorf("sidecar manifest should not be in the archive, stats: %+v, error: %+v", zs, err)
+	}
+	manifest, err := LoadManifest(zipFilePath + ManifestSidecarSuffix)
+	if err != nil || len(manifest.Entries) != len(testFilePaths) {
+		t.Fatalf("LoadManifest manifest: %+v, error: %+v", manifest, err)
+	}
+	if err := manifest.VerifyArchive(zipFilePath, "pw"); err != nil {
+		t.Errorf("VerifyArchive error: %+v", err)
+	}
+
+	manifest.Entries[0].SHA256 = "00"
+	var ie *IntegrityError
+	if err := manifest.VerifyArchive(zipFilePath, "pw"); !errors.As(err, &ie) || ie.Path != manifest.Entries[0].Path {
+		t.Errorf("VerifyArchive expected IntegrityError, got: %+v", err)
+	}
+}
+
+// TestVerifyCorrupt flips a byte of a stored entry and checks that Verify reports it, and
+// removes the extracted file.
+func TestVerifyCorrupt(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	buf := bytes.Buffer{}
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "stored.txt", Method: zip.Store})
+	if err != nil {
+		t.Fatalf("CreateHeader error: %+v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write error: %+v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	b := buf.Bytes()
+	i := bytes.Index(b, data)
+	b[i+500] ^= 0xff
+
+	zipFilePath := filepath.Join(t.TempDir(), "corrupt.zip")
+	if err := os.WriteFile(zipFilePath, b, 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	unzipDir := t.TempDir()
+	_, processedPaths, errs := AsyncUnzipWithOptions(zipFilePath, unzipDir, 1, 0755, &UnzipOptions{Verify: true})
+	result, _ := collectResult(processedPaths, errs)
+	var ie *IntegrityError
+	if len(result.Errors) != 1 || !errors.As(result.Errors[0], &ie) {
+		t.Errorf("expected IntegrityError, got: %+v", result.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(unzipDir, "stored.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("corrupt file was not removed")
+	}
+}
@@ -0,0 +1,170 @@
+package ziph
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ownerExtraID is the Info-ZIP Unix extra field, type 3, that holds the uid and gid.
+	ownerExtraID = 0x7875
+	// symlinkMaxLen limits the size of a symlink target read from an archive.
+	symlinkMaxLen = 4096
+)
+
+// ownerExtra returns the Info-ZIP Unix extra field for the owner of info, or nil if the
+// platform does not provide ownership.
+func ownerExtra(info fs.FileInfo) []byte {
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return nil
+	}
+	extra := make([]byte, 15)
+	binary.LittleEndian.PutUint16(extra[0:2], ownerExtraID)
+	binary.LittleEndian.PutUint16(extra[2:4], 11)
+	extra[4] = 1 // version
+	extra[5] = 4
+	binary.LittleEndian.PutUint32(extra[6:10], uint32(uid))
+	extra[10] = 4
+	binary.LittleEndian.PutUint32(extra[11:15], uint32(gid))
+	return extra
+}
+
+// parseOwnerExtra finds and parses the Info-ZIP Unix extra field.
+func parseOwnerExtra(extra []byte) (int, int, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == ownerExtraID && size >= 3 && extra[0] == 1 {
+			uid, rest, ok := readOwnerID(extra[1:size])
+			if !ok {
+				return 0, 0, false
+			}
+			gid, _, ok := readOwnerID(rest)
+			return uid, gid, ok
+		}
+		extra = extra[size:]
+	}
+	return 0, 0, false
+}
+
+// readOwnerID reads a size prefixed, little endian, uid or gid.
+func readOwnerID(b []byte) (int, []byte, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) || b[0] > 8 {
+		return 0, nil, false
+	}
+	id := uint64(0)
+	for i := int(b[0]); i > 0; i-- {
+		id = id<<8 | uint64(b[i])
+	}
+	return int(id), b[1+int(b[0]):], true
+}
+
+// restoreMetadata sets the ownership, permissions, and modification time of an extracted
+// file or directory from zipFile. Symlinks only have ownership restored.
+func restoreMetadata(zipFile *zip.File, path string) error {
+	// Only root can reliably change ownership, so ownership is not restored otherwise. It is
+	// restored first, as changing ownership clears the setuid and setgid bits.
+	if uid, gid, ok := parseOwnerExtra(zipFile.Extra); ok && os.Geteuid() == 0 {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	mode := zipFile.Mode()
+	if mode&fs.ModeSymlink == 0 {
+		if err := os.Chmod(path, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+		modified := zipFile.Modified
+		if !modified.IsZero() {
+			if err := os.Chtimes(path, modified, modified); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreSymlink creates the symlink at outputFilePath, with the target read from zipFile.
+// Links that are absolute, or resolve to a location outside outputPath, are rejected.
+func restoreSymlink(zipFile *zip.File, outputPath string, outputFilePath string, password string) error {
+	rc, err := openEntry(zipFile, password)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rc.Close(); err != nil {
+			fmt.Printf("defer rc.Close() error:%+v\n", err)
+		}
+	}()
+	b, err := io.ReadAll(io.LimitReader(rc, symlinkMaxLen+1))
+	if err != nil {
+		return err
+	}
+	target := string(b)
+	if len(b) > symlinkMaxLen || !symlinkInside(outputPath, outputFilePath, target) {
+		return fmt.Errorf("restoreSymlink invalid link: %s -> %s", outputFilePath, target)
+	}
+
+	if info, err := os.Lstat(outputFilePath); err == nil && !info.IsDir() {
+		if err := os.Remove(outputFilePath); err != nil {
+			return err
+		}
+	}
+	if err := os.Symlink(target, outputFilePath); err != nil {
+		return err
+	}
+	return restoreMetadata(zipFile, outputFilePath)
+}
+
+// symlinkInside returns true if a link at linkPath, to target, resolves inside root.
+// The target is resolved one element at a time, following any links that exist; links
+// created later can still change how the target resolves, so removeFromZip also never
+// writes through a link.
+func symlinkInside(root string, linkPath string, target string) bool {
+	if target == "" || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return false
+	}
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+	inside := func(path string) bool {
+		return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+	}
+
+	current, err := filepath.EvalSymlinks(filepath.Dir(linkPath))
+	if err != nil || !inside(current) {
+		return false
+	}
+	for _, element := range strings.Split(filepath.ToSlash(target), "/") {
+		switch element {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, element)
+			if info, err := os.Lstat(current); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				if current, err = filepath.EvalSymlinks(current); err != nil {
+					return false
+				}
+			}
+		}
+		if !inside(current) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,295 @@
+package ziph
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMetadataZipUnzip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions and symlinks are not supported on windows")
+	}
+
+	srcDir := filepath.Join(t.TempDir(), "src")
+	mtime := time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	subDir := filepath.Join(srcDir, "sub")
+	emptyDir := filepath.Join(srcDir, "empty")
+	filePath := filepath.Join(subDir, "file.txt")
+	if err := os.MkdirAll(subDir, 0755); err != nil {
+		t.Fatalf("MkdirAll error: %+v", err)
+	}
+	if err := os.Mkdir(emptyDir, 0755); err != nil {
+		t.Fatalf("Mkdir error: %+v", err)
+	}
+	if err := os.WriteFile(filePath, []byte("metadata"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	if err := os.Symlink(filepath.Join("sub", "file.txt"), filepath.Join(srcDir, "link")); err != nil {
+		t.Fatalf("Symlink error: %+v", err)
+	}
+	for path, mode := range map[string]os.FileMode{filePath: 0600, subDir: 0750, emptyDir: 0700} {
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Chmod error: %+v", err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Chtimes error: %+v", err)
+		}
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_metadata.zip")
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, []string{srcDir}, []string{filepath.Dir(srcDir)},
+		&ZipOptions{PreserveMetadata: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+
+	unzipDir := t.TempDir()
+	_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, 10, 0755,
+		&UnzipOptions{RestoreMetadata: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions errors: %+v", result.Errors)
+	}
+
+	for _, test := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{filepath.Join("src", "sub", "file.txt"), 0600},
+		{filepath.Join("src", "sub"), fs.ModeDir | 0750},
+		{filepath.Join("src", "empty"), fs.ModeDir | 0700},
+	} {
+		info, err := os.Stat(filepath.Join(unzipDir, test.path))
+		if err != nil {
+			t.Errorf("Stat error: %+v", err)
+			continue
+		}
+		if info.Mode() != test.mode || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s, mode: %v, modified: %v", test.path, info.Mode(), info.ModTime())
+		}
+	}
+
+	target, err := os.Readlink(filepath.Join(unzipDir, "src", "link"))
+	if err != nil || target != filepath.Join("sub", "file.txt") {
+		t.Errorf("Readlink target: %s, error: %+v", target, err)
+	}
+}
+
+// TestMetadataSymlinkEscape checks that links resolving outside the output path are
+// rejected, including a chain of links that are each inside the output path.
+func TestMetadataSymlinkEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not supported on windows")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_symlink.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, link := range []struct{ name, target string }{
+		{"absolute", "/etc/passwd"},
+		{"parent", "../outside"},
+		{"self", "."},
+		{"chain", "self/.."},
+		{"ok", "self/file"},
+	} {
+		header := &zip.FileHeader{Name: link.name, Method: zip.Store}
+		header.SetMode(fs.ModeSymlink | 0777)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("CreateHeader error: %+v", err)
+		}
+		if _, err := w.Write([]byte(link.target)); err != nil {
+			t.Fatalf("Write error: %+v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	f.Close()
+
+	unzipDir := t.TempDir()
+	_, processedPaths, errs := AsyncUnzipWithOptions(zipFilePath, unzipDir, 5, 0755,
+		&UnzipOptions{RestoreMetadata: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 3 {
+		t.Errorf("expected 3 errors, got: %+v", result.Errors)
+	}
+	for _, name := range []string{"absolute", "parent", "chain"} {
+		if _, err := os.Lstat(filepath.Join(unzipDir, name)); err == nil {
+			t.Errorf("link was created: %s", name)
+		}
+	}
+	for _, name := range []string{"self", "ok"} {
+		if _, err := os.Lstat(filepath.Join(unzipDir, name)); err != nil {
+			t.Errorf("link was not created: %s", name)
+		}
+	}
+}
+
+// TestMetadataSymlinkChainEscape checks that a file is not written through a link that a
+// later link changed to resolve outside the output path.
+func TestMetadataSymlinkChainEscape(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not supported on windows")
+	}
+
+	parentDir := t.TempDir()
+	zipFilePath := filepath.Join(parentDir, "test_symlink_chain.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	zw := zip.NewWriter(f)
+	for _, entry := range []struct {
+		name, data string
+		mode       fs.FileMode
+	}{
+		{"x", "y/../outside", fs.ModeSymlink | 0777},
+		{"y", ".", fs.ModeSymlink | 0777},
+		{"x/pwned", "pwned", 0644},
+	} {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Store}
+		header.SetMode(entry.mode)
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("CreateHeader error: %+v", err)
+		}
+		if _, err := w.Write([]byte(entry.data)); err != nil {
+			t.Fatalf("Write error: %+v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	f.Close()
+
+	// The link resolves to an existing directory outside the output path.
+	if err := os.Mkdir(filepath.Join(parentDir, "outside"), 0755); err != nil {
+		t.Fatalf("Mkdir error: %+v", err)
+	}
+	pwned := filepath.Join(parentDir, "outside", "pwned")
+	unzipDir := filepath.Join(parentDir, "unzip")
+	_, processedPaths, errs := AsyncUnzipWithOptions(zipFilePath, unzipDir, 5, 0755,
+		&UnzipOptions{RestoreMetadata: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 1 {
+		t.Errorf("expected 1 error, got: %+v", result.Errors)
+	}
+	if _, err := os.Lstat(pwned); err == nil {
+		t.Errorf("file was written outside the output path")
+	}
+
+	// DiffDir with Apply extracts with RestoreMetadata, so it must also be safe.
+	diffDir := filepath.Join(parentDir, "diff")
+	if err := os.Mkdir(diffDir, 0755); err != nil {
+		t.Fatalf("Mkdir error: %+v", err)
+	}
+	if _, err := DiffDir(zipFilePath, diffDir, &DiffOptions{Apply: true}); err == nil {
+		t.Errorf("DiffDir with Apply did not return an error")
+	}
+	if _, err := os.Lstat(pwned); err == nil {
+		t.Errorf("file was written outside the output path by DiffDir")
+	}
+}
+
+func TestOwnerExtra(t *testing.T) {
+	info, err := os.Stat(".")
+	if err != nil {
+		t.Fatalf("Stat error: %+v", err)
+	}
+	wantUID, wantGID, ok := fileOwner(info)
+	if !ok {
+		t.Skip("ownership is not supported on this platform")
+	}
+	uid, gid, ok := parseOwnerExtra(append([]byte{0x55, 0x54, 1, 0, 1}, ownerExtra(info)...))
+	if !ok || uid != wantUID || gid != wantGID {
+		t.Errorf("parseOwnerExtra uid: %d, gid: %d, ok: %t", uid, gid, ok)
+	}
+}
+
+// TestMetadataSetuid checks that the setuid and setgid bits are restored along with
+// ownership, which clears them when changed.
+func TestMetadataSetuid(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("restoring ownership requires root")
+	}
+
+	srcDir := filepath.Join(t.TempDir(), "src")
+	if err := os.Mkdir(srcDir, 0755); err != nil {
+		t.Fatalf("Mkdir error: %+v", err)
+	}
+	filePath := filepath.Join(srcDir, "setuid")
+	if err := os.WriteFile(filePath, []byte("setuid"), 0755); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	if err := os.Chmod(filePath, 0755|os.ModeSetuid|os.ModeSetgid); err != nil {
+		t.Fatalf("Chmod error: %+v", err)
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_setuid.zip")
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, []string{filePath}, []string{srcDir},
+		&ZipOptions{PreserveMetadata: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+	unzipDir := t.TempDir()
+	_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, 1, 0755,
+		&UnzipOptions{RestoreMetadata: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions errors: %+v", result.Errors)
+	}
+
+	info, err := os.Stat(filepath.Join(unzipDir, "setuid"))
+	if err != nil {
+		t.Fatalf("Stat error: %+v", err)
+	}
+	if info.Mode() != 0755|os.ModeSetuid|os.ModeSetgid {
+		t.Errorf("mode: %v", info.Mode())
+	}
+}
+
+// TestUnzipExistingSymlink checks that a link already in the output tree is followed by
+// default, and rejected with RestoreMetadata, where the archive can create links.
+func TestUnzipExistingSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not supported on windows")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_existing_symlink.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	b, err := NewBuilder(f, nil)
+	if err != nil {
+		t.Fatalf("NewBuilder error: %+v", err)
+	}
+	if err := b.AddBytes("data/file.txt", []byte("data"), 0644); err != nil {
+		t.Fatalf("AddBytes error: %+v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	f.Close()
+
+	for _, restore := range []bool{false, true} {
+		targetDir := t.TempDir()
+		unzipDir := t.TempDir()
+		if err := os.Symlink(targetDir, filepath.Join(unzipDir, "data")); err != nil {
+			t.Fatalf("Symlink error: %+v", err)
+		}
+		_, err := Unzip(zipFilePath, unzipDir, 0755, &UnzipOptions{RestoreMetadata: restore})
+		_, serr := os.Stat(filepath.Join(targetDir, "file.txt"))
+		if restore && (err == nil || serr == nil) {
+			t.Errorf("RestoreMetadata wrote through an existing link, error: %+v", err)
+		}
+		if !restore && (err != nil || serr != nil) {
+			t.Errorf("Unzip did not follow an existing link, error: %+v, stat error: %+v", err, serr)
+		}
+	}
+}
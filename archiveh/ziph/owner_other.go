@@ -0,0 +1,12 @@
+//go:build !unix
+
+package ziph
+
+import (
+	"io/fs"
+)
+
+// fileOwner is not supported on this platform.
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,175 @@
+package ziph
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// OverwritePolicy specifies what AsyncUnzipWithOptions does when an extracted file already exists.
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces existing files.
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteNever keeps existing files.
+	OverwriteNever
+	// OverwriteIfNewer replaces existing files that have an older modification time than the entry.
+	OverwriteIfNewer
+	// OverwriteIfDifferent replaces existing files that differ from the entry in size or CRC32.
+	// AE-2 encrypted entries do not store a CRC32, so only the size is compared.
+	OverwriteIfDifferent
+)
+
+// extractState records the entries that have been extracted, in a state file, so an
+// interrupted extraction can be resumed.
+type extractState struct {
+	completed map[string]stateEntry
+	file      *os.File
+	path      string
+}
+
+// stateEntry is a line in the state file; the first line only has Archive set.
+type stateEntry struct {
+	Archive string `json:",omitempty"`
+	CRC32   uint32 `json:",omitempty"`
+	Name    string `json:",omitempty"`
+	Size    uint64 `json:",omitempty"`
+}
+
+// openState opens, or creates, the state file for inputPath. If the state file was for a
+// different archive it is reset.
+func openState(statePath string, inputPath string) (*extractState, error) {
+	es := extractState{completed: make(map[string]stateEntry), path: statePath}
+
+	f, err := os.Open(statePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for line := 0; scanner.Scan(); line++ {
+			se := stateEntry{}
+			// A partial last line, from a crash, is ignored.
+			if err := json.Unmarshal(scanner.Bytes(), &se); err != nil {
+				continue
+			}
+			if line == 0 && se.Archive != inputPath {
+				break
+			}
+			if se.Name != "" {
+				es.completed[se.Name] = se
+			}
+		}
+		if err := f.Close(); err != nil {
+			fmt.Printf("f.Close() error:%+v\n", err)
+		}
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if len(es.completed) == 0 {
+		flag |= os.O_TRUNC
+	}
+	if es.file, err = os.OpenFile(statePath, flag, 0644); err != nil {
+		return nil, err
+	}
+	if len(es.completed) == 0 {
+		if err := es.write(stateEntry{Archive: inputPath}); err != nil {
+			return nil, err
+		}
+	}
+	return &es, nil
+}
+
+// done returns true if zipFile was extracted, per the state file.
+func (es *extractState) done(zipFile *zip.File) bool {
+	se, ok := es.completed[zipFile.Name]
+	return ok && se.CRC32 == zipFile.CRC32 && se.Size == zipFile.UncompressedSize64
+}
+
+// finish closes the state file; if the extraction completed it is removed.
+func (es *extractState) finish(completed bool) error {
+	if es.file == nil {
+		return nil
+	}
+	err := es.file.Close()
+	es.file = nil
+	if err != nil || !completed {
+		return err
+	}
+	return os.Remove(es.path)
+}
+
+// record records that zipFile was extracted.
+func (es *extractState) record(zipFile *zip.File) error {
+	return es.write(stateEntry{CRC32: zipFile.CRC32, Name: zipFile.Name, Size: zipFile.UncompressedSize64})
+}
+
+func (es *extractState) write(se stateEntry) error {
+	b, err := json.Marshal(se)
+	if err != nil {
+		return err
+	}
+	_, err = es.file.Write(append(b, '\n'))
+	return err
+}
+
+// skipExisting returns true if the file at path exists and should not be replaced by
+// zipFile, per the policy.
+func skipExisting(zipFile *zip.File, path string, policy OverwritePolicy) (bool, error) {
+	if policy == OverwriteAlways {
+		return false, nil
+	}
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch policy {
+	case OverwriteNever:
+		return true, nil
+	case OverwriteIfNewer:
+		return !zipFile.Modified.After(info.ModTime()), nil
+	case OverwriteIfDifferent:
+		if !info.Mode().IsRegular() || uint64(info.Size()) != zipFile.UncompressedSize64 {
+			return false, nil
+		}
+		if isAES(zipFile) && zipFile.CRC32 == 0 {
+			return true, nil
+		}
+		sum, err := fileCRC32(path)
+		if err != nil {
+			return false, err
+		}
+		return sum == zipFile.CRC32, nil
+	}
+	return false, fmt.Errorf("ziph: invalid overwrite policy: %d", policy)
+}
+
+// fileCRC32 computes the CRC32 of the file at path.
+func fileCRC32(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("defer f.Close() error:%+v\n", err)
+		}
+	}()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
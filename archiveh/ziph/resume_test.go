@@ -0,0 +1,156 @@
+package ziph
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOverwritePolicy(t *testing.T) {
+	zipFilePath := createResumeArchive(t)
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		policy   OverwritePolicy
+		existing string
+		mtime    time.Time
+		want     string
+	}{
+		{OverwriteAlways, "a-old", future, "a.txt"},
+		{OverwriteNever, "a-old", past, "a-old"},
+		{OverwriteIfNewer, "a-old", past, "a.txt"},
+		{OverwriteIfNewer, "a-old", future, "a-old"},
+		// Same size, different CRC32.
+		{OverwriteIfDifferent, "A.TXT", future, "a.txt"},
+		{OverwriteIfDifferent, "a-old-longer", future, "a.txt"},
+	}
+	for _, test := range tests {
+		unzipDir := t.TempDir()
+		path := filepath.Join(unzipDir, "a.txt")
+		if err := os.WriteFile(path, []byte(test.existing), 0644); err != nil {
+			t.Fatalf("WriteFile error: %+v", err)
+		}
+		if err := os.Chtimes(path, test.mtime, test.mtime); err != nil {
+			t.Fatalf("Chtimes error: %+v", err)
+		}
+
+		_, processedPaths, errs := AsyncUnzipWithOptions(zipFilePath, unzipDir, 3, 0755,
+			&UnzipOptions{Overwrite: test.policy})
+		if result, _ := collectResult(processedPaths, errs); len(result.Paths) != 3 || len(result.Errors) != 0 {
+			t.Fatalf("policy: %d, paths: %d, errors: %+v", test.policy, len(result.Paths), result.Errors)
+		}
+		if b, err := os.ReadFile(path); err != nil || string(b) != test.want {
+			t.Errorf("policy: %d, existing: %s, got: %s, want: %s, error: %+v", test.policy, test.existing, b, test.want, err)
+		}
+		if b, err := os.ReadFile(filepath.Join(unzipDir, "c.txt")); err != nil || string(b) != "c.txt" {
+			t.Errorf("policy: %d, c.txt: %s, error: %+v", test.policy, b, err)
+		}
+	}
+
+	// An identical file is not re-written.
+	unzipDir := t.TempDir()
+	path := filepath.Join(unzipDir, "a.txt")
+	if err := os.WriteFile(path, []byte("a.txt"), 0644); err != nil {
+		t.Fatalf("WriteFile error: %+v", err)
+	}
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatalf("Chtimes error: %+v", err)
+	}
+	_, processedPaths, errs := AsyncUnzipWithOptions(zipFilePath, unzipDir, 3, 0755,
+		&UnzipOptions{Overwrite: OverwriteIfDifferent})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("errors: %+v", result.Errors)
+	}
+	if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("identical file was re-written, info: %+v, error: %+v", info, err)
+	}
+}
+
+func TestResumeStateFile(t *testing.T) {
+	zipFilePath := createResumeArchive(t)
+	unzipDir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "state.jsonl")
+
+	// Record a.txt as extracted, as if a previous extraction was interrupted.
+	rc, err := openReader(zipFilePath)
+	if err != nil {
+		t.Fatalf("openReader error: %+v", err)
+	}
+	defer rc.Close()
+	absZipFilePath, _ := filepath.Abs(zipFilePath)
+	state, err := openState(stateFile, absZipFilePath)
+	if err != nil {
+		t.Fatalf("openState error: %+v", err)
+	}
+	if err := state.record(rc.File[0]); err != nil {
+		t.Fatalf("record error: %+v", err)
+	}
+	// A partial line, as from a crash while writing.
+	if _, err := state.file.WriteString(`{"Name":"b.t`); err != nil {
+		t.Fatalf("WriteString error: %+v", err)
+	}
+	if err := state.finish(false); err != nil {
+		t.Fatalf("finish error: %+v", err)
+	}
+
+	_, processedPaths, errs := AsyncUnzipWithOptions(zipFilePath, unzipDir, 3, 0755,
+		&UnzipOptions{StateFile: stateFile})
+	if result, _ := collectResult(processedPaths, errs); len(result.Paths) != 3 || len(result.Errors) != 0 {
+		t.Fatalf("paths: %d, errors: %+v", len(result.Paths), result.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(unzipDir, "a.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("a.txt should not be extracted, error: %+v", err)
+	}
+	for _, name := range []string{"b.txt", "c.txt"} {
+		if b, err := os.ReadFile(filepath.Join(unzipDir, name)); err != nil || string(b) != name {
+			t.Errorf("name: %s, got: %s, error: %+v", name, b, err)
+		}
+	}
+	if _, err := os.Stat(stateFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("state file should be removed on completion, error: %+v", err)
+	}
+
+	// A state file for a different archive is reset.
+	state, err = openState(stateFile, "other.zip")
+	if err != nil {
+		t.Fatalf("openState error: %+v", err)
+	}
+	if err := state.record(rc.File[0]); err != nil {
+		t.Fatalf("record error: %+v", err)
+	}
+	if err := state.finish(false); err != nil {
+		t.Fatalf("finish error: %+v", err)
+	}
+	if state, err = openState(stateFile, absZipFilePath); err != nil || state.done(rc.File[0]) {
+		t.Errorf("state not reset, error: %+v", err)
+	}
+	state.finish(false)
+}
+
+// createResumeArchive creates an archive with a.txt, b.txt, and c.txt; the content of each
+// is its name.
+func createResumeArchive(t *testing.T) string {
+	zipFilePath := filepath.Join(t.TempDir(), "test_resume.zip")
+	f, err := os.Create(zipFilePath)
+	if err != nil {
+		t.Fatalf("Create error: %+v", err)
+	}
+	defer f.Close()
+	b, err := NewBuilder(f, nil)
+	if err != nil {
+		t.Fatalf("NewBuilder error: %+v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := b.AddBytes(name, []byte(name), 0); err != nil {
+			t.Fatalf("AddBytes error: %+v", err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close error: %+v", err)
+	}
+	return zipFilePath
+}
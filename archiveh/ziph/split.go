@@ -0,0 +1,199 @@
+package ziph
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// multiReaderAt is an io.ReaderAt over the concatenation of files.
+type multiReaderAt struct {
+	files   []*os.File
+	offsets []int64
+	size    int64
+}
+
+// readCloser is a zip.Reader for a single archive file, or the volumes of a split archive.
+type readCloser struct {
+	*zip.Reader
+	files []*os.File
+}
+
+// volumeWriter writes sequentially to volume files of at most size bytes.
+type volumeWriter struct {
+	count   int64
+	file    *os.File
+	size    int64
+	volume  int
+	zipPath string
+}
+
+func (mra *multiReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("ziph: negative offset")
+	}
+	n := 0
+	// Index of the first file with an offset past off; the file to read from is the one before.
+	i := sort.Search(len(mra.offsets), func(i int) bool { return mra.offsets[i] > off }) - 1
+	for ; n < len(p) && i >= 0 && i < len(mra.files); i++ {
+		m, err := mra.files[i].ReadAt(p[n:], off+int64(n)-mra.offsets[i])
+		n += m
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (rc *readCloser) Close() error {
+	errs := []error{}
+	for _, f := range rc.files {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (vw *volumeWriter) Close() error {
+	if vw.file == nil {
+		return nil
+	}
+	err := vw.file.Close()
+	vw.file = nil
+	// Remove volumes left from a previous archive with more volumes.
+	for v := vw.volume + 1; ; v++ {
+		if rerr := os.Remove(VolumePath(vw.zipPath, v)); rerr != nil {
+			break
+		}
+	}
+	return err
+}
+
+func (vw *volumeWriter) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if vw.file == nil || vw.count == vw.size {
+			if err := vw.nextVolume(); err != nil {
+				return n, err
+			}
+		}
+		chunk := p[n:]
+		if int64(len(chunk)) > vw.size-vw.count {
+			chunk = chunk[:vw.size-vw.count]
+		}
+		m, err := vw.file.Write(chunk)
+		n += m
+		vw.count += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// nextVolume closes the current volume and creates the next.
+func (vw *volumeWriter) nextVolume() error {
+	if vw.file != nil {
+		if err := vw.file.Close(); err != nil {
+			return err
+		}
+	}
+	vw.volume++
+	f, err := os.Create(VolumePath(vw.zipPath, vw.volume))
+	if err != nil {
+		vw.file = nil
+		return err
+	}
+	vw.file = f
+	vw.count = 0
+	return nil
+}
+
+// VolumePath returns the path of volume number volume, starting at 1, of a split archive;
+// I.E. archive.zip.001.
+func VolumePath(zipPath string, volume int) string {
+	return fmt.Sprintf("%s.%03d", zipPath, volume)
+}
+
+// VolumePaths returns the paths of all volumes of the split archive at zipPath, in order.
+func VolumePaths(zipPath string) ([]string, error) {
+	paths := []string{}
+	for v := 1; ; v++ {
+		path := VolumePath(zipPath, v)
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("ziph: no volumes found for: %s", zipPath)
+	}
+	return paths, nil
+}
+
+// createArchive creates the output for AsyncZipWithOptions; a single file, or volumes when
+// volumeSize is greater than zero. Volumes are written as the archive split into pieces,
+// the same as 7-Zip split volumes; they can also be joined with cat or copy /b.
+func createArchive(zipPath string, volumeSize int64) (io.WriteCloser, error) {
+	if volumeSize <= 0 {
+		return os.Create(zipPath)
+	}
+	// An existing archive at zipPath would be opened instead of the volumes.
+	if err := os.Remove(zipPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	vw := &volumeWriter{size: volumeSize, zipPath: zipPath}
+	if err := vw.nextVolume(); err != nil {
+		return nil, err
+	}
+	return vw, nil
+}
+
+// openReader opens the archive at zipPath; if zipPath does not exist but split archive
+// volumes do, the volumes are opened as a single archive. All registered decompressors
+// are registered with the reader.
+func openReader(zipPath string) (*readCloser, error) {
+	paths := []string{zipPath}
+	if _, err := os.Stat(zipPath); errors.Is(err, fs.ErrNotExist) {
+		if volumes, verr := VolumePaths(zipPath); verr == nil {
+			paths = volumes
+		}
+	}
+
+	rc := &readCloser{}
+	mra := &multiReaderAt{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		rc.files = append(rc.files, f)
+		info, err := f.Stat()
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		mra.files = append(mra.files, f)
+		mra.offsets = append(mra.offsets, mra.size)
+		mra.size += info.Size()
+	}
+
+	zr, err := zip.NewReader(mra, mra.size)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	rc.Reader = zr
+	registerDecompressors(zr)
+	return rc, nil
+}
@@ -0,0 +1,105 @@
+package ziph
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulfdunn/go-helper/cryptoh/v2"
+)
+
+func TestSplitZipUnzip(t *testing.T) {
+	testFilePaths, err := createTestFiles(t)
+	if err != nil {
+		t.Fatalf("test files not created.")
+	}
+
+	zipFilePath := filepath.Join(t.TempDir(), "test_split.zip")
+	trim := filepath.Dir(testFilePaths[0])
+	volumeSize := int64(300000)
+	_, processedPaths, errs := AsyncZipWithOptions(zipFilePath, testFilePaths, []string{trim},
+		&ZipOptions{VolumeSize: volumeSize})
+	if result, _ := collectResult(processedPaths, errs); len(result.Paths) != len(testFilePaths) || len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions paths: %d, errors: %+v", len(result.Paths), result.Errors)
+	}
+
+	if _, err := os.Stat(zipFilePath); err == nil {
+		t.Errorf("zipPath should not exist for a split archive")
+	}
+	volumes, err := VolumePaths(zipFilePath)
+	if err != nil || len(volumes) < 4 {
+		t.Fatalf("VolumePaths volumes: %v, error: %+v", volumes, err)
+	}
+	for _, volume := range volumes {
+		info, err := os.Stat(volume)
+		if err != nil || info.Size() > volumeSize {
+			t.Errorf("volume: %s, info: %+v, error: %+v", volume, info, err)
+		}
+	}
+
+	zs, err := GetZipStats(zipFilePath)
+	if err != nil || zs.FileCount != len(testFilePaths) {
+		t.Fatalf("GetZipStats stats: %+v, error: %+v", zs, err)
+	}
+	unzipDir := t.TempDir()
+	_, processedPaths, errs = AsyncUnzipWithOptions(zipFilePath, unzipDir, zs.FileCount, 0755,
+		&UnzipOptions{Verify: true})
+	if result, _ := collectResult(processedPaths, errs); len(result.Paths) != len(testFilePaths) || len(result.Errors) != 0 {
+		t.Fatalf("AsyncUnzipWithOptions paths: %d, errors: %+v", len(result.Paths), result.Errors)
+	}
+	for _, tp := range testFilePaths {
+		testInputHash, _ := cryptoh.Sha256FileHash(tp)
+		outputFileHash, _ := cryptoh.Sha256FileHash(filepath.Join(unzipDir, filepath.Base(tp)))
+		if !bytes.Equal(testInputHash, outputFileHash) {
+			t.Error("input and output hashes are not equal.")
+		}
+	}
+
+	// Re-create with larger volumes; volumes left from the first archive are removed.
+	_, processedPaths, errs = AsyncZipWithOptions(zipFilePath, testFilePaths, []string{trim},
+		&ZipOptions{VolumeSize: 4 * volumeSize})
+	if result, _ := collectResult(processedPaths, errs); len(result.Errors) != 0 {
+		t.Fatalf("AsyncZipWithOptions errors: %+v", result.Errors)
+	}
+	if volumes, err = VolumePaths(zipFilePath); err != nil || len(volumes) != 1 {
+		t.Errorf("VolumePaths volumes: %v, error: %+v", volumes, err)
+	}
+	if zs, err := GetZipStats(zipFilePath); err != nil || zs.FileCount != len(testFilePaths) {
+		t.Errorf("GetZipStats stats: %+v, error: %+v", zs, err)
+	}
+}
+
+func TestMultiReaderAt(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("0123456789abcdefghij")
+	mra := &multiReaderAt{}
+	for i, part := range [][]byte{data[:7], data[7:8], data[8:]} {
+		path := filepath.Join(dir, VolumePath("part", i+1))
+		if err := os.WriteFile(path, part, 0644); err != nil {
+			t.Fatalf("WriteFile error: %+v", err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Open error: %+v", err)
+		}
+		defer f.Close()
+		mra.files = append(mra.files, f)
+		mra.offsets = append(mra.offsets, mra.size)
+		mra.size += int64(len(part))
+	}
+
+	for off := 0; off < len(data); off++ {
+		for n := 1; off+n <= len(data); n++ {
+			p := make([]byte, n)
+			if m, err := mra.ReadAt(p, int64(off)); m != n || err != nil || !bytes.Equal(p, data[off:off+n]) {
+				t.Fatalf("ReadAt off: %d, n: %d, got: %s, error: %+v", off, n, p[:m], err)
+			}
+		}
+	}
+	p := make([]byte, 5)
+	if m, err := mra.ReadAt(p, int64(len(data)-2)); m != 2 || err != io.EOF {
+		t.Errorf("ReadAt past end m: %d, error: %+v", m, err)
+	}
+}
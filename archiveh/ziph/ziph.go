@@ -3,14 +3,89 @@ package ziph
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// UnzipOptions are optional settings for AsyncUnzipWithOptions.
+type UnzipOptions struct {
+	// Overwrite specifies what is done when an extracted file already exists; the zero value
+	// is OverwriteAlways. Skipped files are still returned on the processed paths channel.
+	Overwrite OverwritePolicy
+	// Password is used to decrypt WinZip AES encrypted entries. Entries that are not
+	// encrypted are extracted normally.
+	Password string
+	// RestoreMetadata restores modification times, permission bits (including directories),
+	// and symlinks. Symlinks that would resolve outside outputPath are rejected, and, as the
+	// archive can create links, nothing is written through an existing link below
+	// outputPath. Ownership is restored when the archive has it and the process is running
+	// as root.
+	RestoreMetadata bool
+	// StateFile, when not empty, is the path of a file that records the entries that have been
+	// extracted. If the extraction is canceled, or the process exits, running it again with the
+	// same StateFile only extracts the remaining entries. The StateFile is removed when the
+	// extraction completes without errors.
+	StateFile string
+	// Verify checks the CRC32 and size of every extracted file against the archive while
+	// streaming, and the authentication code of AE-2 encrypted entries, which store no
+	// CRC32; files that do not match are removed and an *IntegrityError is returned.
+	Verify bool
+}
+
+// ZipOptions are optional settings for AsyncZipWithOptions.
+type ZipOptions struct {
+	// CompressionLevel is passed to the compressor; for zip.Deflate use 1 (flate.BestSpeed)
+	// to 9 (flate.BestCompression). Zero uses the default level.
+	CompressionLevel int
+	// Deterministic creates byte identical archives from identical inputs: entries are sorted by
+	// name, names use '/' as the separator, the modification time of every entry is ModTime,
+	// permissions are normalized to 0644/0755 for files and 0755 for directories, and no
+	// ownership is stored. Not supported with Password.
+	Deterministic bool
+	// Manifest specifies if, and where, a SHA-256 manifest of the zipped files is written.
+	Manifest ManifestMode
+	// Method is the compression method for entries that are not stored; it must be zip.Deflate
+	// or a method added with RegisterCompressor. Zero (zip.Store) uses zip.Deflate.
+	Method uint16
+	// ModTime is the modification time of every entry when Deterministic is set; the zero
+	// value uses DefaultModTime.
+	ModTime time.Time
+	// Password, when not empty, encrypts every file entry using WinZip AE-2 AES-256.
+	// Directory entries are not encrypted.
+	Password string
+	// PreserveMetadata stores symlinks as links, rather than following them, and stores
+	// file ownership where the platform provides it.
+	PreserveMetadata bool
+	// ProbeCompressibility compresses the start of each file, and stores the file if it
+	// does not compress.
+	ProbeCompressibility bool
+	// StoreExtensions is a list of file extensions, I.E. ".jpg", that are stored without
+	// compression. See DefaultStoreExtensions.
+	StoreExtensions []string
+	// VolumeSize, when greater than zero, splits the archive into volumes of at most VolumeSize
+	// bytes, named by VolumePath; zipPath itself is not created.
+	VolumeSize int64
+}
+
+// zipEntry is a walked path, and the header used to add it to an archive. When isSymlink
+// is true the link itself, rather than the target, is added.
+type zipEntry struct {
+	header    *zip.FileHeader
+	info      fs.FileInfo
+	isSymlink bool
+	path      string
+}
+
 // ZipStats is for getting statistics on a zip file; currently only
 // supports the number of zip.File in an archive.
 type ZipStats struct {
@@ -21,16 +96,29 @@ type ZipStats struct {
 // created if it does not exist. Directories are created with permDir permissions.
 // Set the bufSize to the number of files (from GetZipStats) to prevent this function
 // from being blocked output channels not being read fast enough.
+// If inputPath does not exist, but split archive volumes (see ZipOptions.VolumeSize) do,
+// the volumes are read as a single archive.
 // Progress can be monitored via the returned channels, which return cancel, processed
 // paths, and any errors. The cancel channel can be used to cancel an operation.
 // The operation is complete when both processed paths and errors channels are closed.
 func AsyncUnzip(inputPath, outputPath string, bufSize int, permDir os.FileMode) (chan<- bool, <-chan string, <-chan error) {
+	return AsyncUnzipWithOptions(inputPath, outputPath, bufSize, permDir, nil)
+}
+
+// AsyncUnzipWithOptions is AsyncUnzip with options; a nil options is the same as AsyncUnzip.
+// Errors for a single entry are returned as an *EntryError; encrypted entries that cannot be
+// decrypted with options.Password return an *EntryError wrapping a *PasswordError.
+func AsyncUnzipWithOptions(inputPath, outputPath string, bufSize int, permDir os.FileMode,
+	options *UnzipOptions) (chan<- bool, <-chan string, <-chan error) {
+	if options == nil {
+		options = &UnzipOptions{}
+	}
 	cancel := make(chan bool, 1)
 	// Size channels so that they don't block if the caller is only checking done.
 	processedPaths := make(chan string, bufSize)
 	errors := make(chan error, bufSize)
 	go func() {
-		zr, err := zip.OpenReader(inputPath)
+		zr, err := openReader(inputPath)
 		if err != nil {
 			errors <- err
 			close(processedPaths)
@@ -51,6 +139,26 @@ func AsyncUnzip(inputPath, outputPath string, bufSize int, permDir os.FileMode)
 			return
 		}
 
+		var state *extractState
+		if options.StateFile != "" {
+			absInputPath, err := filepath.Abs(inputPath)
+			if err == nil {
+				state, err = openState(options.StateFile, absInputPath)
+			}
+			if err != nil {
+				errors <- err
+				close(processedPaths)
+				close(errors)
+				return
+			}
+			defer func() {
+				if err := state.finish(false); err != nil {
+					fmt.Printf("defer state.finish() error:%+v\n", err)
+				}
+			}()
+		}
+
+		failed := false
 		for _, f := range zr.File {
 			select {
 			case <-cancel:
@@ -60,9 +168,41 @@ func AsyncUnzip(inputPath, outputPath string, bufSize int, permDir os.FileMode)
 				return
 			default:
 			}
-			err := removeFromZip(f, outputPath, permDir)
+			if state != nil && state.done(f) {
+				processedPaths <- filepath.Join(outputPath, f.Name)
+				continue
+			}
+			err := removeFromZip(f, outputPath, permDir, options)
 			processedPaths <- filepath.Join(outputPath, f.Name)
 			if err != nil {
+				failed = true
+				errors <- &EntryError{Path: f.Name, Err: err}
+			} else if state != nil {
+				if err := state.record(f); err != nil {
+					failed = true
+					errors <- &EntryError{Path: f.Name, Err: err}
+				}
+			}
+		}
+
+		if options.RestoreMetadata {
+			// Directory metadata is restored last, and deepest first, as extracting files
+			// changes the directory modification time and the permissions may not allow writing.
+			for i := len(zr.File) - 1; i >= 0; i-- {
+				f := zr.File[i]
+				if !f.FileInfo().IsDir() || !validOutputPath(outputPath, f.Name) ||
+					checkNoSymlink(outputPath, f.Name) != nil {
+					continue
+				}
+				if err := restoreMetadata(f, filepath.Join(outputPath, f.Name)); err != nil {
+					failed = true
+					errors <- &EntryError{Path: f.Name, Err: err}
+				}
+			}
+		}
+
+		if state != nil {
+			if err := state.finish(!failed); err != nil {
 				errors <- err
 			}
 		}
@@ -81,12 +221,35 @@ func AsyncUnzip(inputPath, outputPath string, bufSize int, permDir os.FileMode)
 // which return cancel, processed paths, and any errors. The cancel channel can be used to cancel an
 // operation. The operation is complete when both processed paths and errors channels are closed.
 func AsyncZip(zipPath string, paths []string, trimFilepath []string) (chan<- bool, <-chan string, <-chan error) {
+	return AsyncZipWithOptions(zipPath, paths, trimFilepath, nil)
+}
+
+// AsyncZipWithOptions is AsyncZip with options; a nil options is the same as AsyncZip.
+// Errors for a single path are returned as an *EntryError.
+func AsyncZipWithOptions(zipPath string, paths []string, trimFilepath []string,
+	options *ZipOptions) (chan<- bool, <-chan string, <-chan error) {
+	if options == nil {
+		options = &ZipOptions{}
+	}
 	cancel := make(chan bool, 1)
 	// Size channels so that they don't block if the caller is only checking done.
 	processedPaths := make(chan string, len(paths))
 	errors := make(chan error, len(paths))
 	go func() {
-		f, err := os.Create(zipPath)
+		if options.Deterministic && options.Password != "" {
+			// Encryption uses a random salt, so the output can not be reproducible.
+			errors <- fmt.Errorf("AsyncZip Deterministic is not supported with Password")
+			close(processedPaths)
+			close(errors)
+			return
+		}
+
+		var manifest *Manifest
+		if options.Manifest != ManifestNone {
+			manifest = &Manifest{Entries: []ManifestEntry{}}
+		}
+
+		f, err := createArchive(zipPath, options.VolumeSize)
 		if err != nil {
 			errors <- err
 			close(processedPaths)
@@ -100,27 +263,62 @@ func AsyncZip(zipPath string, paths []string, trimFilepath []string) (chan<- boo
 		}()
 
 		zipWriter := zip.NewWriter(f)
+		registerCompressors(zipWriter, options.CompressionLevel)
 		defer func() {
 			//nolint:errcheck
 			// The error will always be "zip: writer closed twice", which is not typed and not useful to log.
 			zipWriter.Close()
 		}()
 
-		for _, path := range paths {
+		canceled := func() bool {
 			select {
 			case <-cancel:
 				errors <- fmt.Errorf("AsyncZip canceled")
 				close(processedPaths)
 				close(errors)
-				return
+				return true
 			default:
 			}
-			err = filepath.WalkDir(path, addToZip(zipWriter, trimFilepath, errors))
-			processedPaths <- path
+			return false
+		}
+
+		// In Deterministic mode all paths are walked, then the entries sorted and written,
+		// so the entry order does not depend on the order of paths.
+		entries := []*zipEntry{}
+		for _, path := range paths {
+			if canceled() {
+				return
+			}
+			if options.Deterministic {
+				err = filepath.WalkDir(path, collectZipEntries(&entries, trimFilepath, options, errors))
+			} else {
+				err = filepath.WalkDir(path, addToZip(zipWriter, trimFilepath, options, manifest, errors))
+				processedPaths <- path
+			}
 			if err != nil {
 				errors <- err
 			}
 		}
+		if options.Deterministic {
+			sortZipEntries(entries)
+			for _, ze := range entries {
+				if canceled() {
+					return
+				}
+				if err := ze.write(zipWriter, options, manifest); err != nil {
+					errors <- &EntryError{Path: ze.path, Err: err}
+				}
+			}
+			for _, path := range paths {
+				processedPaths <- path
+			}
+		}
+
+		if manifest != nil {
+			if err := writeManifest(zipWriter, zipPath, manifest, options); err != nil {
+				errors <- err
+			}
+		}
 
 		if err := zipWriter.Close(); err != nil {
 			fmt.Printf("zipWriter.Close error:%+v\n", err)
@@ -135,7 +333,7 @@ func AsyncZip(zipPath string, paths []string, trimFilepath []string) (chan<- boo
 // GetZipStats is for getting statistics on a zip file; currently only
 // supports the number of zip.File in an archive.
 func GetZipStats(inputPath string) (*ZipStats, error) {
-	zr, err := zip.OpenReader(inputPath)
+	zr, err := openReader(inputPath)
 	if err != nil {
 		return nil, err
 	}
@@ -155,75 +353,124 @@ func GetZipStats(inputPath string) (*ZipStats, error) {
 // addToZip is a closure called by Create to add a directory or file to the zipWriter;
 // do not directly call this function.
 // The paths are turned into absolute paths, then made relative by removing
-// the leading filepath.Separator.
-func addToZip(zipWriter *zip.Writer, trimFilepath []string, errors chan error) func(string, fs.DirEntry, error) error {
+// the leading filepath.Separator. When manifest is not nil, an entry is added to it for each file.
+func addToZip(zipWriter *zip.Writer, trimFilepath []string, options *ZipOptions, manifest *Manifest,
+	errors chan error) func(string, fs.DirEntry, error) error {
 	return func(path string, dirEntry fs.DirEntry, err error) error {
 		if err != nil {
-			errors <- err
+			errors <- &EntryError{Path: path, Err: err}
 			return fs.SkipDir
 		}
 
-		info, err := dirEntry.Info()
-		if err != nil {
-			return err
+		ze, err := newZipEntry(path, dirEntry, trimFilepath, options)
+		if err == nil {
+			err = ze.write(zipWriter, options, manifest)
 		}
-
-		header, err := zip.FileInfoHeader(info)
 		if err != nil {
-			return err
-		}
-		zipFilepath, err := filepath.Abs(path)
-		if err != nil {
-			return err
+			return &EntryError{Path: path, Err: err}
 		}
-		header.Name = zipFilepath
-		for _, trm := range trimFilepath {
-			if strings.HasPrefix(zipFilepath, trm) {
-				header.Name = strings.TrimPrefix(zipFilepath, trm)
-				break
-			}
-		}
-		header.Name = strings.TrimPrefix(header.Name, string(filepath.Separator))
-		if info.IsDir() {
-			header.Name += string(filepath.Separator)
-		}
-		header.Method = zip.Deflate
+		return nil
+	}
+}
 
-		headerWriter, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
+// newZipEntry creates the zipEntry, including the header, for a walked path.
+func newZipEntry(path string, dirEntry fs.DirEntry, trimFilepath []string, options *ZipOptions) (*zipEntry, error) {
+	info, err := dirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	isSymlink := info.Mode()&fs.ModeSymlink != 0
+	if isSymlink && !options.PreserveMetadata {
+		// The link is followed, so the header must describe the target.
+		if info, err = os.Stat(path); err != nil {
+			return nil, err
 		}
+	}
 
-		if info.IsDir() {
-			return nil
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	if options.PreserveMetadata {
+		header.Extra = append(header.Extra, ownerExtra(info)...)
+	}
+	zipFilepath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = zipFilepath
+	for _, trm := range trimFilepath {
+		if strings.HasPrefix(zipFilepath, trm) {
+			header.Name = strings.TrimPrefix(zipFilepath, trm)
+			break
 		}
+	}
+	header.Name = strings.TrimPrefix(header.Name, string(filepath.Separator))
+	if info.IsDir() {
+		header.Name += string(filepath.Separator)
+	}
+	header.Method = zip.Deflate
+	if options.Deterministic {
+		normalizeHeader(header, info.Mode(), options)
+	}
+
+	return &zipEntry{header: header, info: info, isSymlink: isSymlink && options.PreserveMetadata, path: path}, nil
+}
 
-		f, err := os.Open(path)
+// write writes the zipEntry to the zipWriter. When manifest is not nil, an entry is added
+// to it for each file.
+func (ze *zipEntry) write(zipWriter *zip.Writer, options *ZipOptions, manifest *Manifest) error {
+	header := ze.header
+	if ze.info.IsDir() {
+		_, err := zipWriter.CreateHeader(header)
+		return err
+	}
+
+	if ze.isSymlink {
+		// The link target is the entry data; links are not included in the manifest.
+		target, err := os.Readlink(ze.path)
 		if err != nil {
 			return err
 		}
-		defer func() {
-			if err := f.Close(); err != nil {
-				fmt.Printf("defer f.Close() error:%+v\n", err)
-			}
-		}()
+		return writeEntry(zipWriter, header, strings.NewReader(target), options)
+	}
 
-		_, err = io.Copy(headerWriter, f)
+	f, err := os.Open(ze.path)
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			fmt.Printf("defer f.Close() error:%+v\n", err)
+		}
+	}()
+	if header.Method, err = entryMethod(header.Name, f, options); err != nil {
+		return err
+	}
+	return writeFileEntry(zipWriter, header, f, options, manifest)
 }
 
 // removeFromZip removes a zipFile from its archive. The outputPath is checked
 // for Zip Slip (https://github.com/golang/go/issues/40373) and an error is returned for
-// inappropriate paths.
-func removeFromZip(zipFile *zip.File, outputPath string, permDir os.FileMode) error {
+// inappropriate paths. WinZip AES encrypted entries are decrypted with options.Password.
+func removeFromZip(zipFile *zip.File, outputPath string, permDir os.FileMode, options *UnzipOptions) error {
 	// zipFile.Name is a relative path and file name
 	outputFilePath := filepath.Join(outputPath, zipFile.Name)
-	// Reject paths that might Zip Slip; I.E. if zipFile.Name uses ../ to access
-	// directories outside outputPath the file is rejected.
-	if !strings.HasPrefix(outputFilePath, filepath.Clean(outputPath)+string(os.PathSeparator)) {
+	if !validOutputPath(outputPath, zipFile.Name) {
 		return fmt.Errorf("removeFromZip invalid file path: %s", outputFilePath)
 	}
+	// Only archives extracted with RestoreMetadata create links, so links already in the
+	// output tree are otherwise followed. A symlink entry replaces an existing link, so only
+	// its parents are checked.
+	if options.RestoreMetadata {
+		checkPath := zipFile.Name
+		if zipFile.Mode()&fs.ModeSymlink != 0 {
+			checkPath = path.Dir(strings.TrimSuffix(zipFile.Name, "/"))
+		}
+		if err := checkNoSymlink(outputPath, checkPath); err != nil {
+			return err
+		}
+	}
 
 	if zipFile.FileInfo().IsDir() {
 		if err := os.MkdirAll(outputFilePath, permDir); err != nil {
@@ -236,28 +483,134 @@ func removeFromZip(zipFile *zip.File, outputPath string, permDir os.FileMode) er
 		return err
 	}
 
-	f, err := os.OpenFile(outputFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, zipFile.Mode())
+	if skip, err := skipExisting(zipFile, outputFilePath, options.Overwrite); err != nil || skip {
+		return err
+	}
+
+	if options.RestoreMetadata && zipFile.Mode()&fs.ModeSymlink != 0 {
+		return restoreSymlink(zipFile, outputPath, outputFilePath, options.Password)
+	}
+
+	// Open the entry before creating the output file, so a bad password does not
+	// leave an empty file behind.
+	irc, err := openEntry(zipFile, options.Password)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Printf("defer f.Close() error:%+v\n", err)
+		if err := irc.Close(); err != nil {
+			fmt.Printf("defer irc.Close() error:%+v\n", err)
 		}
 	}()
 
-	irc, err := zipFile.Open()
+	f, err := os.OpenFile(outputFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, zipFile.Mode())
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if err := irc.Close(); err != nil {
-			fmt.Printf("defer irc.Close() error:%+v\n", err)
+		if err := f.Close(); err != nil {
+			fmt.Printf("defer f.Close() error:%+v\n", err)
 		}
 	}()
 
-	if _, err := io.Copy(f, irc); err != nil {
+	if options.Verify {
+		if err := verifyCopy(f, irc, zipFile); err != nil {
+			if rerr := os.Remove(outputFilePath); rerr != nil {
+				fmt.Printf("os.Remove() error:%+v\n", rerr)
+			}
+			return err
+		}
+	} else if _, err := io.Copy(f, irc); err != nil {
+		return err
+	}
+
+	if options.RestoreMetadata {
+		return restoreMetadata(zipFile, outputFilePath)
+	}
+	return nil
+}
+
+// verifyCopy copies r to w, checking the CRC32 and size of the copied data against zipFile.
+func verifyCopy(w io.Writer, r io.Reader, zipFile *zip.File) error {
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	switch {
+	case errors.Is(err, zip.ErrChecksum):
+		return &IntegrityError{Path: zipFile.Name, Reason: "crc32 mismatch"}
+	case errors.Is(err, errAuthentication):
+		return &IntegrityError{Path: zipFile.Name, Reason: errAuthentication.Error()}
+	case err != nil:
+		return err
+	case uint64(n) != zipFile.UncompressedSize64:
+		return &IntegrityError{Path: zipFile.Name,
+			Reason: fmt.Sprintf("size mismatch, archive: %d, extracted: %d", zipFile.UncompressedSize64, n)}
+	case zipFile.CRC32 != 0 && h.Sum32() != zipFile.CRC32:
+		// AE-2 encrypted entries store a zero CRC32; the authentication code is verified by the
+		// reader when the data is read to the end.
+		return &IntegrityError{Path: zipFile.Name,
+			Reason: fmt.Sprintf("crc32 mismatch, archive: %08x, extracted: %08x", zipFile.CRC32, h.Sum32())}
+	}
+	return nil
+}
+
+// checkNoSymlink returns an error if any existing element of name, below outputPath, is a
+// symlink, so nothing is written through a link. Checking link targets when links are
+// created is not enough, as a later link can change where an earlier link resolves.
+func checkNoSymlink(outputPath string, name string) error {
+	current := filepath.Clean(outputPath)
+	for _, element := range strings.Split(filepath.Clean(filepath.FromSlash(name)), string(filepath.Separator)) {
+		if element == "" || element == "." {
+			continue
+		}
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("removeFromZip path contains a symlink: %s", current)
+		}
+	}
+	return nil
+}
+
+// validOutputPath rejects paths that might Zip Slip; I.E. if name uses ../ to access
+// directories outside outputPath the name is rejected.
+func validOutputPath(outputPath string, name string) bool {
+	return strings.HasPrefix(filepath.Join(outputPath, name), filepath.Clean(outputPath)+string(os.PathSeparator))
+}
+
+// writeFileEntry writes the data from r to a new entry in zipWriter, per writeEntry. When
+// manifest is not nil, an entry is added to it.
+func writeFileEntry(zipWriter *zip.Writer, header *zip.FileHeader, r io.Reader, options *ZipOptions,
+	manifest *Manifest) error {
+	if manifest == nil {
+		return writeEntry(zipWriter, header, r, options)
+	}
+	h := sha256.New()
+	cw := &countWriter{}
+	if err := writeEntry(zipWriter, header, io.TeeReader(r, io.MultiWriter(h, cw)), options); err != nil {
 		return err
 	}
+	manifest.Entries = append(manifest.Entries,
+		ManifestEntry{Path: header.Name, SHA256: hex.EncodeToString(h.Sum(nil)), Size: cw.count})
 	return nil
 }
+
+// writeEntry writes the data from r to a new entry in zipWriter, encrypting the data
+// if options.Password is set.
+func writeEntry(zipWriter *zip.Writer, header *zip.FileHeader, r io.Reader, options *ZipOptions) error {
+	if options.Password != "" {
+		return writeAES(zipWriter, header, r, options.Password, options.CompressionLevel)
+	}
+
+	headerWriter, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(headerWriter, r)
+	return err
+}
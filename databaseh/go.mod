@@ -3,6 +3,13 @@ module github.com/paulfdunn/go-helper/databaseh/v2
 go 1.21.8
 
 require (
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/paulfdunn/go-helper/osh/v2 v2.1.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 )
@@ -0,0 +1,226 @@
+package kvs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// querier is implemented by *sql.DB and *sql.Tx, so operations can run with or without
+// a transaction.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Prepare(query string) (*sql.Stmt, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tx is a transaction, from Update; all operations on a Tx are committed, or rolled back,
+// together. A Tx must not be used after the function passed to Update returns.
+type Tx struct {
+	// cacheClear and cacheKeys are removed from the cache of the KVS after the commit.
+	cacheClear bool
+	cacheKeys  []string
+	kvs        KVS
+	tx         *sql.Tx
+}
+
+// DeleteMany deletes keys from the KVS, in a single transaction; returns the count of keys
+// that were deleted.
+func (kvs KVS) DeleteMany(keys []string) (int64, error) {
+	var count int64
+	err := kvs.Update(func(tx *Tx) error {
+		var err error
+		count, err = tx.DeleteMany(keys)
+		return err
+	})
+	return count, err
+}
+
+// GetMany gets the values for keys, in a single read only transaction, so all values are
+// from the same snapshot. Keys that do not exist, or have expired, are not in the returned map.
+func (kvs KVS) GetMany(keys []string) (map[string][]byte, error) {
+	if kvs.dbConn == nil {
+		return nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	sqlTx, err := kvs.readConn.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("begin transaction", err)
+	}
+	defer func() {
+		if err := sqlTx.Rollback(); err != nil {
+			fmt.Printf("sqlTx.Rollback() error:%+v\n", err)
+		}
+	}()
+	return (&Tx{kvs: kvs, tx: sqlTx}).GetMany(keys)
+}
+
+// SetMany sets all key/value pairs in values, in a single transaction, using one prepared
+// statement. This is much faster than calling Set for each key; see BenchmarkSetMany.
+func (kvs KVS) SetMany(values map[string][]byte) error {
+	return kvs.Update(func(tx *Tx) error {
+		return tx.SetMany(values)
+	})
+}
+
+// Update calls fn with a transaction. If fn returns nil the transaction is committed;
+// if fn returns an error, or panics, the transaction is rolled back and the error returned,
+// or the panic continued.
+func (kvs KVS) Update(fn func(tx *Tx) error) (err error) {
+	if kvs.dbConn == nil {
+		return fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	sqlTx, err := kvs.dbConn.Begin()
+	if err != nil {
+		return runtimeh.SourceInfoError("begin transaction", err)
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if rerr := sqlTx.Rollback(); rerr != nil {
+			fmt.Printf("sqlTx.Rollback() error:%+v\n", rerr)
+		}
+	}()
+
+	tx := &Tx{kvs: kvs, tx: sqlTx}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return runtimeh.SourceInfoError("commit transaction", err)
+	}
+	committed = true
+	if tx.cacheClear {
+		kvs.cache.clear()
+	} else if len(tx.cacheKeys) > 0 {
+		kvs.cache.remove(tx.cacheKeys...)
+	}
+	return nil
+}
+
+// Delete is KVS.Delete in the transaction.
+func (tx *Tx) Delete(key string) (int64, error) {
+	tx.invalidate(key)
+	return tx.kvs.delete(tx.tx, key)
+}
+
+// DeleteMany is KVS.DeleteMany in the transaction.
+func (tx *Tx) DeleteMany(keys []string) (int64, error) {
+	stmt, err := tx.tx.Prepare(fmt.Sprintf(`DELETE FROM %s WHERE key=?;`, tx.kvs.table))
+	if err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			fmt.Printf("stmt.Close() error:%+v\n", err)
+		}
+	}()
+
+	tx.invalidate(keys...)
+	var total int64
+	for _, key := range keys {
+		res, err := stmt.Exec(key)
+		if err != nil {
+			return 0, runtimeh.SourceInfoError("", err)
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return 0, runtimeh.SourceInfoError("", err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// Get is KVS.Get in the transaction; values set in the transaction are returned.
+func (tx *Tx) Get(key string) ([]byte, error) {
+	return tx.kvs.get(tx.tx, key)
+}
+
+// GetMany is KVS.GetMany in the transaction.
+func (tx *Tx) GetMany(keys []string) (map[string][]byte, error) {
+	stmt, err := tx.tx.Prepare(fmt.Sprintf(`SELECT value FROM %s WHERE key=? AND %s;`, tx.kvs.table, notExpired))
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			fmt.Printf("stmt.Close() error:%+v\n", err)
+		}
+	}()
+
+	now := time.Now().UnixNano()
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		var value []byte
+		err := stmt.QueryRow(key, now).Scan(&value)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, runtimeh.SourceInfoError("scan error", err)
+		}
+		if values[key], err = tx.kvs.keyring.open(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// Set is KVS.Set in the transaction.
+func (tx *Tx) Set(key string, value []byte) error {
+	tx.invalidate(key)
+	return tx.kvs.set(tx.tx, key, value, nil, "")
+}
+
+// SetMany is KVS.SetMany in the transaction.
+func (tx *Tx) SetMany(values map[string][]byte) error {
+	stmt, err := tx.tx.Prepare(fmt.Sprintf(upsert, tx.kvs.table))
+	if err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			fmt.Printf("stmt.Close() error:%+v\n", err)
+		}
+	}()
+
+	now := time.Now().UnixNano()
+	for key, value := range values {
+		tx.invalidate(key)
+		value, err := tx.kvs.keyring.seal(key, value)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(key, value, nil, nil, now, tx.kvs.name); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+	}
+	return nil
+}
+
+// SetWithTTL is KVS.SetWithTTL in the transaction.
+func (tx *Tx) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	tx.invalidate(key)
+	return tx.kvs.set(tx.tx, key, value, expiresAt(ttl), "")
+}
+
+// invalidate records keys to remove from the cache after the commit.
+func (tx *Tx) invalidate(keys ...string) {
+	if tx.kvs.cache != nil && !tx.cacheClear {
+		tx.cacheKeys = append(tx.cacheKeys, keys...)
+	}
+}
+
+// invalidateAll records that the cache is cleared after the commit.
+func (tx *Tx) invalidateAll() {
+	tx.cacheClear = true
+	tx.cacheKeys = nil
+}
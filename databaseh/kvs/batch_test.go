@@ -0,0 +1,168 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestManyOperations(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	values := map[string][]byte{}
+	for i := 0; i < 100; i++ {
+		values[fmt.Sprintf("k%d", i)] = []byte(fmt.Sprintf("v%d", i))
+	}
+	if err := kvs.SetMany(values); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+		return
+	}
+	if count, err := rowCount(kvs.dbConn, table); err != nil || count != len(values) {
+		t.Errorf("rowCount, count: %d, error: %v", count, err)
+	}
+
+	got, err := kvs.GetMany([]string{"k1", "k99", "missing"})
+	if err != nil || len(got) != 2 || string(got["k1"]) != "v1" || string(got["k99"]) != "v99" {
+		t.Errorf("GetMany, values: %v, error: %v", got, err)
+	}
+
+	count, err := kvs.DeleteMany([]string{"k1", "k2", "missing"})
+	if err != nil || count != 2 {
+		t.Errorf("DeleteMany, count: %d, error: %v", count, err)
+	}
+	if count, err := rowCount(kvs.dbConn, table); err != nil || count != len(values)-2 {
+		t.Errorf("rowCount, count: %d, error: %v", count, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	err = kvs.Update(func(tx *Tx) error {
+		if err := tx.Set("k1", []byte("v1")); err != nil {
+			return err
+		}
+		if err := tx.Set("k2", []byte("v2")); err != nil {
+			return err
+		}
+		// Values set in the transaction are visible in the transaction.
+		if b, err := tx.Get("k1"); err != nil || string(b) != "v1" {
+			return fmt.Errorf("tx.Get, value: %s, error: %v", b, err)
+		}
+		_, err := tx.Delete("k2")
+		return err
+	})
+	if err != nil {
+		t.Errorf("Update, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); err != nil || string(b) != "v1" {
+		t.Errorf("Get after commit, value: %s, error: %v", b, err)
+	}
+
+	// An error rolls back all operations.
+	errRollback := errors.New("rollback")
+	err = kvs.Update(func(tx *Tx) error {
+		if err := tx.Set("k1", []byte("changed")); err != nil {
+			return err
+		}
+		if err := tx.Set("k3", []byte("v3")); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Errorf("Update, expected rollback error, got: %v", err)
+	}
+	if b, err := kvs.Get("k1"); err != nil || string(b) != "v1" {
+		t.Errorf("Get after rollback, value: %s, error: %v", b, err)
+	}
+	if b, err := kvs.Get("k3"); err != nil || b != nil {
+		t.Errorf("Get after rollback, value: %s, error: %v", b, err)
+	}
+
+	// A panic rolls back all operations.
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		kvs.Update(func(tx *Tx) error {
+			if err := tx.Set("k3", []byte("v3")); err != nil {
+				return err
+			}
+			panic("test panic")
+		})
+	}()
+	if b, err := kvs.Get("k3"); err != nil || b != nil {
+		t.Errorf("Get after panic, value: %s, error: %v", b, err)
+	}
+}
+
+const benchmarkKeys = 1000
+
+func BenchmarkSet(b *testing.B) {
+	kvs := benchmarkSetup(b)
+	defer kvs.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k := 0; k < benchmarkKeys; k++ {
+			if err := kvs.Set(fmt.Sprintf("k%d", k), []byte("value")); err != nil {
+				b.Fatalf("Set, error: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkSetMany(b *testing.B) {
+	kvs := benchmarkSetup(b)
+	defer kvs.Close()
+	values := map[string][]byte{}
+	for k := 0; k < benchmarkKeys; k++ {
+		values[fmt.Sprintf("k%d", k)] = []byte("value")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := kvs.SetMany(values); err != nil {
+			b.Fatalf("SetMany, error: %v", err)
+		}
+	}
+}
+
+func benchmarkSetup(b *testing.B) KVS {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			b.Fatalf("testSetup error; %+v", err)
+		}
+	}
+	kvs, err := New(dataSourceName, "benchmarkTable")
+	if err != nil {
+		b.Fatalf("New, error: %v", err)
+	}
+	return kvs
+}
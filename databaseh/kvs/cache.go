@@ -0,0 +1,215 @@
+package kvs
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// CacheOptions are the options of the read-through cache used by Get, with Options.Cache.
+// The cache is a least recently used (LRU) cache of values, in this process; at least one of
+// MaxBytes and MaxEntries must be greater than zero.
+//
+// Writes with the same KVS, including copies of the KVS and transactions from Update, remove
+// changed keys from the cache. Writes by other processes, or by another KVS opened on the
+// same table, are not seen until the cached entry expires; use TTL to bound how long stale
+// values can be returned. Values are cached after decryption when a Keyring is used.
+type CacheOptions struct {
+	// MaxBytes, when greater than zero, is the maximum total size of cached keys and values.
+	MaxBytes int64
+	// MaxEntries, when greater than zero, is the maximum count of cached values.
+	MaxEntries int
+	// TTL, when greater than zero, is the maximum time a value is cached. Keys that expire,
+	// from SetWithTTL, are never cached past their expiry time.
+	TTL time.Duration
+}
+
+// CacheStats are the statistics of the cache, from KVS.CacheStats.
+type CacheStats struct {
+	// Bytes is the total size of cached keys and values.
+	Bytes int64
+	// Entries is the count of cached values.
+	Entries int
+	// Evictions is the count of values removed to stay within MaxBytes or MaxEntries.
+	Evictions uint64
+	// Hits is the count of Get calls returned from the cache.
+	Hits uint64
+	// Misses is the count of Get calls read from the database, including missing keys,
+	// which are not cached.
+	Misses uint64
+}
+
+// cache is an LRU cache of values. Methods can be called with a nil cache, which caches
+// nothing, so callers do not check whether caching is enabled.
+type cache struct {
+	entries map[string]*list.Element
+	// generation is incremented whenever entries are removed, so a value read from the
+	// database before a write is not added after the write removed the key.
+	generation uint64
+	// lru holds *cacheEntry, most recently used first.
+	lru     *list.List
+	mutex   sync.Mutex
+	options CacheOptions
+	stats   CacheStats
+}
+
+// cacheEntry is a cached value; a zero expires does not expire.
+type cacheEntry struct {
+	expires time.Time
+	key     string
+	value   []byte
+}
+
+// CacheStats returns the statistics of the cache; all zero when Options.Cache was not set.
+func (kvs KVS) CacheStats() CacheStats {
+	if kvs.cache == nil {
+		return CacheStats{}
+	}
+	kvs.cache.mutex.Lock()
+	defer kvs.cache.mutex.Unlock()
+	return kvs.cache.stats
+}
+
+// newCache returns a cache, or an error if options are not valid.
+func newCache(options CacheOptions) (*cache, error) {
+	if options.MaxBytes <= 0 && options.MaxEntries <= 0 {
+		return nil, fmt.Errorf("%s cache MaxBytes or MaxEntries must be greater than zero", runtimeh.SourceInfo())
+	}
+	return &cache{entries: map[string]*list.Element{}, lru: list.New(), options: options}, nil
+}
+
+// cachedGet is Get using the cache; values read from the database are added to the cache.
+func (kvs KVS) cachedGet(key string) ([]byte, error) {
+	if value, ok := kvs.cache.get(key); ok {
+		return value, nil
+	}
+
+	generation := kvs.cache.currentGeneration()
+	var value []byte
+	var expiresAt sql.NullInt64
+	row := kvs.readConn.QueryRow(fmt.Sprintf(`SELECT value, expires_at FROM %s WHERE key=? AND %s;`, kvs.table, notExpired),
+		key, time.Now().UnixNano())
+	if err := row.Scan(&value, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, runtimeh.SourceInfoError("scan error", err)
+	}
+	value, err := kvs.keyring.open(key, value)
+	if err != nil {
+		return nil, err
+	}
+	kvs.cache.add(key, value, unixNanoTime(expiresAt), generation)
+	return value, nil
+}
+
+// add adds a copy of value, unless entries were removed since generation. A value that
+// expires is cached until expires, or the TTL, whichever is first.
+func (c *cache) add(key string, value []byte, expires time.Time, generation uint64) {
+	if c == nil {
+		return
+	}
+	size := int64(len(key) + len(value))
+	if c.options.MaxBytes > 0 && size > c.options.MaxBytes {
+		return
+	}
+	if c.options.TTL > 0 {
+		if ttlExpires := time.Now().Add(c.options.TTL); expires.IsZero() || ttlExpires.Before(expires) {
+			expires = ttlExpires
+		}
+	}
+	if value != nil {
+		value = append([]byte{}, value...)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{expires: expires, key: key, value: value})
+	c.stats.Bytes += size
+	c.stats.Entries++
+	for (c.options.MaxEntries > 0 && c.stats.Entries > c.options.MaxEntries) ||
+		(c.options.MaxBytes > 0 && c.stats.Bytes > c.options.MaxBytes) {
+		c.removeElement(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// clear removes all entries.
+func (c *cache) clear() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.generation++
+	c.stats.Bytes = 0
+	c.stats.Entries = 0
+}
+
+// currentGeneration returns the generation, for add.
+func (c *cache) currentGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.generation
+}
+
+// get returns a copy of the cached value, and true if the key is cached and the entry has
+// not expired.
+func (c *cache) get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if ok {
+		entry := element.Value.(*cacheEntry)
+		if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+			c.removeElement(element)
+			ok = false
+		}
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.lru.MoveToFront(element)
+	value := element.Value.(*cacheEntry).value
+	if value == nil {
+		return nil, true
+	}
+	return append([]byte{}, value...), true
+}
+
+// remove removes the keys.
+func (c *cache) remove(keys ...string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.removeElement(element)
+		}
+	}
+}
+
+// removeElement removes an entry; the mutex must be held.
+func (c *cache) removeElement(element *list.Element) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.stats.Bytes -= int64(len(entry.key) + len(entry.value))
+	c.stats.Entries--
+}
@@ -0,0 +1,182 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	if _, err := NewWithOptions(dataSourceName, "testTable", &Options{Cache: &CacheOptions{}}); err == nil {
+		t.Errorf("NewWithOptions with unbounded cache did not return an error")
+	}
+	kvs, err := NewWithOptions(dataSourceName, "testTable", &Options{Cache: &CacheOptions{MaxEntries: 2}})
+	if err != nil {
+		t.Errorf("NewWithOptions, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	if err := kvs.Set("k1", []byte("v1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if b, err := kvs.Get("k1"); string(b) != "v1" || err != nil {
+			t.Errorf("Get, value: %s, error: %v", b, err)
+		}
+	}
+	if b, err := kvs.Get("missing"); b != nil || err != nil {
+		t.Errorf("Get missing key, value: %s, error: %v", b, err)
+	}
+	testCacheStats(t, kvs, CacheStats{Bytes: 4, Entries: 1, Hits: 2, Misses: 2})
+
+	// Returned values are copies, so changing them does not change the cache.
+	b, _ := kvs.Get("k1")
+	b[0] = 'x'
+	if b, err := kvs.Get("k1"); string(b) != "v1" || err != nil {
+		t.Errorf("Get after changing value, value: %s, error: %v", b, err)
+	}
+
+	// Writes with this KVS, and transactions, remove keys from the cache.
+	if err := kvs.Set("k1", []byte("v2")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); string(b) != "v2" || err != nil {
+		t.Errorf("Get after Set, value: %s, error: %v", b, err)
+	}
+	if err := kvs.Update(func(tx *Tx) error { return tx.Set("k1", []byte("v3")) }); err != nil {
+		t.Errorf("Update, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); string(b) != "v3" || err != nil {
+		t.Errorf("Get after Update, value: %s, error: %v", b, err)
+	}
+	if _, err := kvs.Delete("k1"); err != nil {
+		t.Errorf("Delete, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); b != nil || err != nil {
+		t.Errorf("Get after Delete, value: %s, error: %v", b, err)
+	}
+
+	// The least recently used key is evicted.
+	if err := kvs.SetMany(map[string][]byte{"k1": []byte("v1"), "k2": []byte("v2"), "k3": []byte("v3")}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+	for _, key := range []string{"k1", "k2", "k1", "k3"} {
+		if _, err := kvs.Get(key); err != nil {
+			t.Errorf("Get, error: %v", err)
+		}
+	}
+	if stats := kvs.CacheStats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("CacheStats, stats: %+v", stats)
+	}
+	hits := kvs.CacheStats().Hits
+	if _, err := kvs.Get("k1"); err != nil || kvs.CacheStats().Hits != hits+1 {
+		t.Errorf("Get of recently used key was not a hit, error: %v", err)
+	}
+
+	if err := kvs.DeleteStore(); err != nil {
+		t.Errorf("DeleteStore, error: %v", err)
+	}
+	if stats := kvs.CacheStats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("CacheStats after DeleteStore, stats: %+v", stats)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	dataSourceName := filepath.Join(t.TempDir(), "test.db")
+	kvs, err := NewWithOptions(dataSourceName, "testTable", &Options{Cache: &CacheOptions{MaxBytes: 10, TTL: 50 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("NewWithOptions, error: %v", err)
+	}
+	defer kvs.Close()
+	// other is as if from another process; its writes do not change the cache of kvs.
+	other, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer other.Close()
+
+	if err := kvs.Set("k1", []byte("v1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, err := kvs.Get("k1"); err != nil {
+		t.Errorf("Get, error: %v", err)
+	}
+	if err := other.Set("k1", []byte("v2")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); string(b) != "v1" || err != nil {
+		t.Errorf("Get cached, value: %s, error: %v", b, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if b, err := kvs.Get("k1"); string(b) != "v2" || err != nil {
+		t.Errorf("Get after cache TTL, value: %s, error: %v", b, err)
+	}
+
+	// Keys that expire are not cached past their expiry, and values larger than MaxBytes are
+	// not cached.
+	if err := kvs.SetWithTTL("k2", []byte("v2"), 10*time.Millisecond); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	if b, err := kvs.Get("k2"); string(b) != "v2" || err != nil {
+		t.Errorf("Get, value: %s, error: %v", b, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if b, err := kvs.Get("k2"); b != nil || err != nil {
+		t.Errorf("Get expired key, value: %s, error: %v", b, err)
+	}
+	if err := kvs.Set("k3", []byte("larger than MaxBytes")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, err := kvs.Get("k3"); err != nil {
+		t.Errorf("Get, error: %v", err)
+	}
+	if stats := kvs.CacheStats(); stats.Bytes > 10 {
+		t.Errorf("CacheStats, stats: %+v", stats)
+	}
+}
+
+func BenchmarkGetCache(b *testing.B) {
+	benchmarks := []struct {
+		name    string
+		options *Options
+	}{
+		{"NoCache", nil},
+		{"Cache", &Options{Cache: &CacheOptions{MaxEntries: benchmarkKeys}}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			kvs, err := NewWithOptions(filepath.Join(b.TempDir(), "benchmark.db"), "benchmarkTable", bm.options)
+			if err != nil {
+				b.Fatalf("NewWithOptions, error: %v", err)
+			}
+			defer kvs.Close()
+			for k := 0; k < benchmarkKeys; k++ {
+				if err := kvs.Set(fmt.Sprintf("k%d", k), []byte("value")); err != nil {
+					b.Fatalf("Set, error: %v", err)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := kvs.Get(fmt.Sprintf("k%d", i%benchmarkKeys)); err != nil {
+					b.Fatalf("Get, error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// testCacheStats checks the statistics of the cache.
+func testCacheStats(t *testing.T, kvs KVS, want CacheStats) {
+	if stats := kvs.CacheStats(); stats != want {
+		t.Errorf("CacheStats, stats: %+v, want: %+v", stats, want)
+	}
+}
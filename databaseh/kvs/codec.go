@@ -0,0 +1,146 @@
+package kvs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes objects for Serialize, and decodes them for Deserialize. Data from a Codec
+// is stored with a header byte, the ID, so Deserialize uses the Codec that encoded the data,
+// whatever the Codec of the store; data encoded with different Codecs can be mixed in one
+// store. Other formats, I.E. protobuf, can be added with RegisterCodec.
+type Codec interface {
+	// ID is the header byte. Zero, only used by JSON, writes no header.
+	ID() byte
+	// Marshal encodes v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v, which is a pointer. Fields that are not in data should
+	// be left unchanged, as Deserialize merges persisted data into the object.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// The built in codecs. JSON is the default, and writes no header, so data serialized before
+// codecs were added, and data written by other JSON encoders, is read as JSON.
+var (
+	CBOR        Codec = cborCodec{}
+	Gob         Codec = gobCodec{}
+	JSON        Codec = jsonCodec{}
+	MessagePack Codec = msgpackCodec{}
+)
+
+var (
+	// cborEncMode encodes time.Time as RFC3339 with nanoseconds; the CBOR default is Unix
+	// seconds, which loses precision.
+	cborEncMode = func() cbor.EncMode {
+		em, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+		if err != nil {
+			panic(err)
+		}
+		return em
+	}()
+	codecs = map[byte]Codec{
+		CBOR.ID():        CBOR,
+		Gob.ID():         Gob,
+		MessagePack.ID(): MessagePack,
+	}
+	codecsMutex sync.RWMutex
+)
+
+// RegisterCodec registers a Codec, so data with its header is decoded by Deserialize; a
+// Codec must be registered before it is used. The ID must be a control character, 0x01 to
+// 0x1f, other than tab, newline, and carriage return, so it is never the first byte of
+// JSON; 0x01 to 0x03 are used by the built in codecs.
+func RegisterCodec(codec Codec) error {
+	id := codec.ID()
+	if id == 0 || id > 0x1f || id == '\t' || id == '\n' || id == '\r' {
+		return fmt.Errorf("%s codec ID is not valid, id: %#x", runtimeh.SourceInfo(), id)
+	}
+	codecsMutex.Lock()
+	defer codecsMutex.Unlock()
+	if _, ok := codecs[id]; ok {
+		return fmt.Errorf("%s codec ID is already registered, id: %#x", runtimeh.SourceInfo(), id)
+	}
+	codecs[id] = codec
+	return nil
+}
+
+// codecFor returns the Codec for data, from the header; data without a header is JSON.
+func codecFor(data []byte) (Codec, error) {
+	if len(data) == 0 || data[0] > 0x1f || data[0] == '\t' || data[0] == '\n' || data[0] == '\r' {
+		return JSON, nil
+	}
+	codecsMutex.RLock()
+	defer codecsMutex.RUnlock()
+	codec, ok := codecs[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("%s no codec registered, id: %#x", runtimeh.SourceInfo(), data[0])
+	}
+	return codec, nil
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ID() byte {
+	return 0x03
+}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cborEncMode.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte {
+	return 0x01
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte {
+	return 0
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte {
+	return 0x02
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,130 @@
+package kvs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+type testCodecObject struct {
+	Bytes []byte
+	ID    int
+	Name  string
+	Time  time.Time
+}
+
+func TestCodecs(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := NewWithOptions(dataSourceName, "testTable", &Options{Codec: MessagePack})
+	if err != nil {
+		t.Fatalf("NewWithOptions, error: %v", err)
+	}
+	defer kvs.Close()
+
+	obj := testCodecObject{Bytes: []byte{0, 1, 2}, ID: 1, Name: "name", Time: time.Now()}
+	codecs := map[string]Codec{"cbor": CBOR, "gob": Gob, "json": JSON, "msgpack": MessagePack}
+	for name, codec := range codecs {
+		if err := kvs.SerializeWithCodec(name, obj, codec); err != nil {
+			t.Errorf("SerializeWithCodec, codec: %s, error: %v", name, err)
+		}
+	}
+	if err := kvs.Serialize("default", obj); err != nil {
+		t.Errorf("Serialize, error: %v", err)
+	}
+	if b, err := kvs.Get("default"); err != nil || len(b) == 0 || b[0] != MessagePack.ID() {
+		t.Errorf("Serialize did not use the KVS codec, value: %v, error: %v", b, err)
+	}
+	if b, err := kvs.Get("json"); err != nil || len(b) == 0 || b[0] != '{' {
+		t.Errorf("JSON has a header, value: %s, error: %v", b, err)
+	}
+
+	// Deserialize uses the codec from the header, whatever the KVS codec.
+	for _, key := range []string{"cbor", "gob", "json", "msgpack", "default"} {
+		got := testCodecObject{}
+		if err := kvs.Deserialize(key, &got); err != nil {
+			t.Errorf("Deserialize, key: %s, error: %v", key, err)
+		}
+		if got.ID != obj.ID || got.Name != obj.Name || string(got.Bytes) != string(obj.Bytes) {
+			t.Errorf("Deserialize, key: %s, got: %+v", key, got)
+		}
+		// Only JSON, of the built in codecs, loses the monotonic clock reading, and the
+		// location is not compared.
+		if !got.Time.Equal(obj.Time) {
+			t.Errorf("Deserialize time, key: %s, got: %v, want: %v", key, got.Time, obj.Time)
+		}
+	}
+
+	// Fields that were not persisted are merged, as with JSON.
+	for _, codec := range []Codec{CBOR, JSON, MessagePack} {
+		if err := kvs.SerializeWithCodec("partial", map[string]interface{}{"ID": 2}, codec); err != nil {
+			t.Errorf("SerializeWithCodec, error: %v", err)
+		}
+		got := testCodecObject{Name: "kept"}
+		if err := kvs.Deserialize("partial", &got); err != nil || got.ID != 2 || got.Name != "kept" {
+			t.Errorf("Deserialize partial, codec: %d, got: %+v, error: %v", codec.ID(), got, err)
+		}
+	}
+
+	// Data with a header of an unregistered codec is an error.
+	if err := kvs.Set("unknown", []byte{0x1f, 1, 2}); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := kvs.Deserialize("unknown", &testCodecObject{}); err == nil {
+		t.Errorf("Deserialize unknown codec did not return an error")
+	}
+	if err := kvs.SerializeWithCodec("unknown", obj, testCodec{id: 0x1e}); err == nil {
+		t.Errorf("SerializeWithCodec unregistered codec did not return an error")
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	for _, id := range []byte{0, '\n', 0x20, '{', Gob.ID()} {
+		if err := RegisterCodec(testCodec{id: id}); err == nil {
+			t.Errorf("RegisterCodec did not return an error, id: %#x", id)
+		}
+	}
+
+	codec := testCodec{id: 0x10}
+	if err := RegisterCodec(codec); err != nil {
+		t.Errorf("RegisterCodec, error: %v", err)
+	}
+	defer func() {
+		codecsMutex.Lock()
+		delete(codecs, codec.id)
+		codecsMutex.Unlock()
+	}()
+	ms := NewMemory()
+	if err := serialize(ms.Set, codec, "k1", "value"); err != nil {
+		t.Errorf("serialize, error: %v", err)
+	}
+	if b, err := ms.Get("k1"); string(b) != "\x10value" || err != nil {
+		t.Errorf("Get, value: %q, error: %v", b, err)
+	}
+	var got string
+	if err := ms.Deserialize("k1", &got); got != "value" || err != nil {
+		t.Errorf("Deserialize, got: %s, error: %v", got, err)
+	}
+}
+
+// testCodec is a Codec for strings, which are stored as is.
+type testCodec struct {
+	id byte
+}
+
+func (c testCodec) ID() byte {
+	return c.id
+}
+
+func (testCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(v.(string)), nil
+}
+
+func (testCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*string) = string(data)
+	return nil
+}
@@ -0,0 +1,253 @@
+package kvs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// sealedMagic starts every encrypted value; it is followed by the key ID length (one byte),
+// the key ID, the nonce, and the AES-GCM ciphertext.
+var sealedMagic = []byte{0x00, 'k', 'v', 'e'}
+
+// Keyring holds the AES keys used to encrypt values at rest, with Options.Keyring. Values
+// are encrypted with the current key, and the ID of that key is stored with each value, so
+// values encrypted with an older key are still decrypted as long as that key is in the
+// Keyring. The key is authenticated data, so a value copied to another key can not be
+// decrypted. Only values are encrypted; keys are stored in plaintext, in the table and in
+// the change log used by Watch.
+//
+// To rotate keys, call Rotate with the new key, then KVS.ReEncrypt to re-encrypt existing
+// values; the old key can then be removed from the Keyring. Values stored without
+// encryption, I.E. before a Keyring was used, are returned unchanged, and are encrypted by
+// ReEncrypt. A Keyring is safe for concurrent use.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+	mutex   sync.RWMutex
+}
+
+// NewKeyring creates a Keyring with key as the current key. The key must be 16, 24, or 32
+// bytes, for AES-128, AES-192, or AES-256; the id must be 1 to 255 bytes.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]cipher.AEAD)}
+	if err := kr.Rotate(id, key); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Add adds a key, which is only used to decrypt values; I.E. a key that was rotated out,
+// for values not yet re-encrypted. An existing key with the same id is replaced.
+func (kr *Keyring) Add(id string, key []byte) error {
+	if len(id) == 0 || len(id) > 255 {
+		return fmt.Errorf("%s key id length must be 1 to 255 bytes, id: %q", runtimeh.SourceInfo(), id)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	kr.keys[id] = aead
+	return nil
+}
+
+// Current returns the id of the key used to encrypt values.
+func (kr *Keyring) Current() string {
+	kr.mutex.RLock()
+	defer kr.mutex.RUnlock()
+	return kr.current
+}
+
+// Remove removes a key; values encrypted with it can no longer be decrypted. The current
+// key can not be removed.
+func (kr *Keyring) Remove(id string) error {
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	if id == kr.current {
+		return fmt.Errorf("%s can not remove the current key, id: %s", runtimeh.SourceInfo(), id)
+	}
+	delete(kr.keys, id)
+	return nil
+}
+
+// Rotate adds a key, as Add, and makes it the current key; new values are encrypted with
+// it. Existing values keep their key until they are set again, or re-encrypted.
+func (kr *Keyring) Rotate(id string, key []byte) error {
+	if err := kr.Add(id, key); err != nil {
+		return err
+	}
+	kr.mutex.Lock()
+	defer kr.mutex.Unlock()
+	kr.current = id
+	return nil
+}
+
+// ReEncrypt re-encrypts every value that is not encrypted with the current key of the
+// Keyring, including values stored without encryption, in transactions of batchSize rows;
+// returns the count of values re-encrypted. Versions are not changed, but each re-encrypted
+// value is an OpPut event for Watch.
+func (kvs KVS) ReEncrypt(batchSize int) (int64, error) {
+	if kvs.dbConn == nil {
+		return 0, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	if kvs.keyring == nil {
+		return 0, fmt.Errorf("%s kvs has no keyring", runtimeh.SourceInfo())
+	}
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("%s batchSize must be greater than zero", runtimeh.SourceInfo())
+	}
+
+	var total int64
+	var rowid int64
+	for {
+		read := 0
+		err := kvs.Update(func(tx *Tx) error {
+			var err error
+			read, rowid, err = tx.reEncrypt(rowid, batchSize, &total)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		if read < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// reEncrypt re-encrypts up to batchSize rows after rowid, adding the count re-encrypted to
+// total; returns the count of rows read and the last rowid read.
+func (tx *Tx) reEncrypt(rowid int64, batchSize int, total *int64) (int, int64, error) {
+	type row struct {
+		key   string
+		rowid int64
+		value []byte
+	}
+	rows, err := tx.tx.Query(fmt.Sprintf(`SELECT rowid, key, value FROM %s WHERE rowid > ? ORDER BY rowid LIMIT ?;`,
+		tx.kvs.table), rowid, batchSize)
+	if err != nil {
+		return 0, rowid, runtimeh.SourceInfoError("", err)
+	}
+	batch := []row{}
+	for rows.Next() {
+		r := row{}
+		if err := rows.Scan(&r.rowid, &r.key, &r.value); err != nil {
+			rows.Close()
+			return 0, rowid, runtimeh.SourceInfoError("scan error", err)
+		}
+		batch = append(batch, r)
+	}
+	err = rows.Err()
+	if cerr := rows.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, rowid, runtimeh.SourceInfoError("scan iteration error", err)
+	}
+
+	current := tx.kvs.keyring.Current()
+	for _, r := range batch {
+		rowid = r.rowid
+		if r.value == nil || sealedKeyID(r.value) == current {
+			continue
+		}
+		value, err := tx.kvs.keyring.open(r.key, r.value)
+		if err != nil {
+			return 0, rowid, err
+		}
+		if value, err = tx.kvs.keyring.seal(r.key, value); err != nil {
+			return 0, rowid, err
+		}
+		res, err := tx.tx.Exec(fmt.Sprintf(`UPDATE %s SET value=? WHERE rowid=?;`, tx.kvs.table), value, r.rowid)
+		if err != nil {
+			return 0, rowid, runtimeh.SourceInfoError("", err)
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return 0, rowid, runtimeh.SourceInfoError("", err)
+		}
+		*total += count
+	}
+	return len(batch), rowid, nil
+}
+
+// open returns the plaintext of a value from the database. Values that are not encrypted
+// are returned unchanged, as are all values when kr is nil.
+func (kr *Keyring) open(key string, value []byte) ([]byte, error) {
+	if kr == nil || !bytes.HasPrefix(value, sealedMagic) {
+		return value, nil
+	}
+	id := sealedKeyID(value)
+	if id == "" {
+		return nil, fmt.Errorf("%s encrypted value is truncated, key: %s", runtimeh.SourceInfo(), key)
+	}
+
+	kr.mutex.RLock()
+	aead, ok := kr.keys[id]
+	kr.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s encryption key not in keyring, id: %s, key: %s", runtimeh.SourceInfo(), id, key)
+	}
+	sealed := value[len(sealedMagic)+1+len(id):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%s encrypted value is truncated, key: %s", runtimeh.SourceInfo(), key)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, runtimeh.SourceInfoError(fmt.Sprintf("decrypting key: %s", key), err)
+	}
+	if plaintext == nil {
+		// An empty value is not nil, which Get returns for a missing key.
+		plaintext = []byte{}
+	}
+	return plaintext, nil
+}
+
+// seal encrypts a value, for key, with the current key. A nil value, or a nil kr, returns
+// the value unchanged.
+func (kr *Keyring) seal(key string, value []byte) ([]byte, error) {
+	if kr == nil || value == nil {
+		return value, nil
+	}
+
+	kr.mutex.RLock()
+	id := kr.current
+	aead := kr.keys[id]
+	kr.mutex.RUnlock()
+
+	sealed := make([]byte, 0, len(sealedMagic)+1+len(id)+aead.NonceSize()+len(value)+aead.Overhead())
+	sealed = append(sealed, sealedMagic...)
+	sealed = append(sealed, byte(len(id)))
+	sealed = append(sealed, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, runtimeh.SourceInfoError("", err)
+	}
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, value, []byte(key)), nil
+}
+
+// sealedKeyID returns the key ID of an encrypted value, or an empty string if the value is
+// not encrypted or is truncated.
+func sealedKeyID(value []byte) string {
+	if !bytes.HasPrefix(value, sealedMagic) || len(value) < len(sealedMagic)+1 {
+		return ""
+	}
+	idLen := int(value[len(sealedMagic)])
+	if len(value) < len(sealedMagic)+1+idLen {
+		return ""
+	}
+	return string(value[len(sealedMagic)+1 : len(sealedMagic)+1+idLen])
+}
@@ -0,0 +1,155 @@
+package kvs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	// A value stored before encryption is used.
+	plain, err := New(dataSourceName, table)
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer plain.Close()
+	if err := plain.Set("plain", []byte("plaintext")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+
+	keyring, err := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring, error: %v", err)
+	}
+	kvs, err := NewWithOptions(dataSourceName, table, &Options{Keyring: keyring})
+	if err != nil {
+		t.Fatalf("NewWithOptions, error: %v", err)
+	}
+	defer kvs.Close()
+
+	if err := kvs.Set("token", []byte("secret")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := kvs.Set("empty", []byte{}); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := kvs.SetMany(map[string][]byte{"many": []byte("secret")}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+	ts := TestSerialize{ID: 1, Name: "name"}
+	if err := kvs.Serialize("obj", ts); err != nil {
+		t.Errorf("Serialize, error: %v", err)
+	}
+	for _, key := range []string{"token", "many", "obj"} {
+		if b, err := plain.Get(key); !bytes.HasPrefix(b, sealedMagic) || bytes.Contains(b, []byte("secret")) || err != nil {
+			t.Errorf("value not encrypted, key: %s, value: %q, error: %v", key, b, err)
+		}
+	}
+
+	if b, err := kvs.Get("token"); string(b) != "secret" || err != nil {
+		t.Errorf("Get, value: %s, error: %v", b, err)
+	}
+	if b, err := kvs.Get("empty"); b == nil || len(b) != 0 || err != nil {
+		t.Errorf("Get empty, value: %v, error: %v", b, err)
+	}
+	if b, err := kvs.Get("plain"); string(b) != "plaintext" || err != nil {
+		t.Errorf("Get plain, value: %s, error: %v", b, err)
+	}
+	if values, err := kvs.GetMany([]string{"token", "many"}); string(values["many"]) != "secret" || err != nil {
+		t.Errorf("GetMany, values: %v, error: %v", values, err)
+	}
+	tsd := TestSerialize{}
+	if err := kvs.Deserialize("obj", &tsd); err != nil || tsd != ts {
+		t.Errorf("Deserialize, tsd: %+v, error: %v", tsd, err)
+	}
+	if kvps, err := kvs.Scan(&ScanOptions{Prefix: "token", Values: true}); err != nil || len(kvps) != 1 || string(kvps[0].Value) != "secret" {
+		t.Errorf("Scan, kvps: %+v, error: %v", kvps, err)
+	}
+	version, err := kvs.CompareAndSwap("token", 1, []byte("swapped"))
+	if err != nil {
+		t.Errorf("CompareAndSwap, error: %v", err)
+	}
+	if b, v, err := kvs.GetWithVersion("token"); string(b) != "swapped" || v != version || err != nil {
+		t.Errorf("GetWithVersion, value: %s, version: %d, error: %v", b, v, err)
+	}
+
+	// A value moved to another key is not decrypted.
+	b, err := plain.Get("many")
+	if err != nil {
+		t.Errorf("Get, error: %v", err)
+	}
+	if err := plain.Set("moved", b); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, err := kvs.Get("moved"); err == nil {
+		t.Errorf("Get moved value did not return an error")
+	}
+	if _, err := plain.Delete("moved"); err != nil {
+		t.Errorf("Delete, error: %v", err)
+	}
+
+	// Rotate, then re-encrypt; values encrypted with k1 are readable until then.
+	if err := keyring.Rotate("k2", bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Errorf("Rotate, error: %v", err)
+	}
+	if err := keyring.Remove("k2"); err == nil {
+		t.Errorf("Remove current key did not return an error")
+	}
+	if b, err := kvs.Get("token"); string(b) != "swapped" || err != nil {
+		t.Errorf("Get after Rotate, value: %s, error: %v", b, err)
+	}
+	if count, err := kvs.ReEncrypt(2); count != 5 || err != nil {
+		t.Errorf("ReEncrypt, count: %d, error: %v", count, err)
+	}
+	if count, err := kvs.ReEncrypt(2); count != 0 || err != nil {
+		t.Errorf("ReEncrypt again, count: %d, error: %v", count, err)
+	}
+	if err := keyring.Remove("k1"); err != nil {
+		t.Errorf("Remove, error: %v", err)
+	}
+	for key, want := range map[string]string{"token": "swapped", "many": "secret", "plain": "plaintext"} {
+		if b, err := kvs.Get(key); string(b) != want || err != nil {
+			t.Errorf("Get after ReEncrypt, key: %s, value: %s, error: %v", key, b, err)
+		}
+		if b, err := plain.Get(key); sealedKeyID(b) != "k2" || err != nil {
+			t.Errorf("not encrypted with k2, key: %s, value: %q, error: %v", key, b, err)
+		}
+	}
+	if _, v, err := kvs.GetWithVersion("token"); v != version || err != nil {
+		t.Errorf("ReEncrypt changed version, version: %d, error: %v", v, err)
+	}
+
+	// Without the key, values can not be decrypted.
+	other, err := NewKeyring("k1", bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewKeyring, error: %v", err)
+	}
+	wrong, err := NewWithOptions(dataSourceName, table, &Options{Keyring: other})
+	if err != nil {
+		t.Fatalf("NewWithOptions, error: %v", err)
+	}
+	defer wrong.Close()
+	if _, err := wrong.Get("token"); err == nil {
+		t.Errorf("Get without key did not return an error")
+	}
+
+	if _, err := plain.ReEncrypt(10); err == nil {
+		t.Errorf("ReEncrypt without keyring did not return an error")
+	}
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	if _, err := NewKeyring("k1", []byte("short")); err == nil {
+		t.Errorf("NewKeyring with short key did not return an error")
+	}
+	if _, err := NewKeyring("", bytes.Repeat([]byte{1}, 32)); err == nil {
+		t.Errorf("NewKeyring with empty id did not return an error")
+	}
+}
@@ -0,0 +1,137 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// ImportMode selects how Import combines imported keys with existing keys.
+type ImportMode int
+
+const (
+	// ImportMerge sets the imported keys; existing keys that are not imported are kept.
+	ImportMerge ImportMode = iota
+	// ImportReplace deletes all existing keys, then sets the imported keys.
+	ImportReplace
+)
+
+// exportRecord is one line of an export.
+type exportRecord struct {
+	ContentType *string `json:"content_type,omitempty"`
+	ExpiresAt   *int64  `json:"expires_at,omitempty"`
+	Key         string  `json:"key"`
+	Value       []byte  `json:"value"`
+}
+
+// Backup writes a copy of the whole database, all stores, to a new SQLite database file
+// at path, using VACUUM INTO. The copy is consistent. With JournalMode WAL (see
+// Options.SQLite) writers are not blocked while it is made; with the default rollback
+// journal, writes wait until the copy is done. Restore by opening the file with New. It is
+// an error if path exists.
+func (kvs KVS) Backup(path string) error {
+	if kvs.dbConn == nil {
+		return fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	if _, err := kvs.dbConn.Exec(`VACUUM INTO ?;`, path); err != nil {
+		return runtimeh.SourceInfoError("backup", err)
+	}
+	return nil
+}
+
+// Export writes all keys, except expired keys, to w as JSON Lines: one JSON object per
+// line, with key, value (base64), expires_at (Unix nanoseconds) if the key expires, and
+// content_type if the key has one. Created and updated times are not exported; imported keys
+// are updated at the time of the import. Values are written as stored; values encrypted
+// with a Keyring stay encrypted. Keys are written in ascending order, from a consistent
+// snapshot.
+func (kvs KVS) Export(w io.Writer) (int64, error) {
+	if kvs.dbConn == nil {
+		return 0, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	rows, err := kvs.readConn.Query(fmt.Sprintf(`SELECT key, value, expires_at, content_type FROM %s WHERE %s ORDER BY key;`,
+		kvs.table, notExpired), time.Now().UnixNano())
+	if err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("rows.Close() error:%+v\n", err)
+		}
+	}()
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	var count int64
+	for rows.Next() {
+		record := exportRecord{}
+		if err := rows.Scan(&record.Key, &record.Value, &record.ExpiresAt, &record.ContentType); err != nil {
+			return count, runtimeh.SourceInfoError("scan error", err)
+		}
+		if err := encoder.Encode(record); err != nil {
+			return count, runtimeh.SourceInfoError("", err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, runtimeh.SourceInfoError("scan iteration error", err)
+	}
+	return count, runtimeh.SourceInfoError("", bw.Flush())
+}
+
+// Import reads JSON Lines, as written by Export, from r, and sets the keys using mode; returns
+// the count of keys imported. The import is a single transaction, so if any line is not
+// valid, nothing is imported. Values are set as stored in the export, so values encrypted
+// with a Keyring require the same keys. Imported keys that have expired are not set.
+func (kvs KVS) Import(r io.Reader, mode ImportMode) (int64, error) {
+	if mode != ImportMerge && mode != ImportReplace {
+		return 0, fmt.Errorf("%s invalid import mode: %d", runtimeh.SourceInfo(), mode)
+	}
+	var count int64
+	err := kvs.Update(func(tx *Tx) error {
+		tx.invalidateAll()
+		if mode == ImportReplace {
+			if _, err := tx.tx.Exec(fmt.Sprintf(`DELETE FROM %s;`, kvs.table)); err != nil {
+				return runtimeh.SourceInfoError("", err)
+			}
+		}
+		stmt, err := tx.tx.Prepare(fmt.Sprintf(upsert, kvs.table))
+		if err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+		defer func() {
+			if err := stmt.Close(); err != nil {
+				fmt.Printf("stmt.Close() error:%+v\n", err)
+			}
+		}()
+
+		now := time.Now().UnixNano()
+		decoder := json.NewDecoder(r)
+		for n := 1; ; n++ {
+			record := exportRecord{}
+			if err := decoder.Decode(&record); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return runtimeh.SourceInfoError(fmt.Sprintf("record: %d", n), err)
+			}
+			if record.ExpiresAt != nil && *record.ExpiresAt <= now {
+				continue
+			}
+			if _, err := stmt.Exec(record.Key, record.Value, record.ExpiresAt, record.ContentType, now, kvs.name); err != nil {
+				return runtimeh.SourceInfoError("", err)
+			}
+			count++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
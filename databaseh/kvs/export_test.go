@@ -0,0 +1,146 @@
+package kvs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+	if err := kvs.SetMany(map[string][]byte{"k1": []byte("v1"), "k2": {0, 0xff}}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+	if err := kvs.SetWithTTL("ttl", []byte("ttl"), time.Hour); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	if err := kvs.SetWithTTL("expired", []byte("expired"), time.Nanosecond); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	var b bytes.Buffer
+	if count, err := kvs.Export(&b); count != 3 || err != nil {
+		t.Errorf("Export, count: %d, error: %v", count, err)
+	}
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], `{"key":"k1"`) {
+		t.Errorf("Export, lines: %q", lines)
+	}
+	export := b.String()
+
+	// Import into another store, as on another machine.
+	other, err := New(dataSourceName, "otherTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer other.Close()
+	if err := other.Set("existing", []byte("existing")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if count, err := other.Import(strings.NewReader(export), ImportMerge); count != 3 || err != nil {
+		t.Errorf("Import merge, count: %d, error: %v", count, err)
+	}
+	testExportKeys(t, other, "[existing k1 k2 ttl]")
+	if b, err := other.Get("k2"); !bytes.Equal(b, []byte{0, 0xff}) || err != nil {
+		t.Errorf("Get, value: %v, error: %v", b, err)
+	}
+	var expiresAt int64
+	if err := other.dbConn.QueryRow(`SELECT expires_at FROM otherTable WHERE key='ttl';`).Scan(&expiresAt); err != nil ||
+		time.Until(time.Unix(0, expiresAt)) < 59*time.Minute {
+		t.Errorf("ttl not imported, expiresAt: %d, error: %v", expiresAt, err)
+	}
+
+	if count, err := other.Import(strings.NewReader(export), ImportReplace); count != 3 || err != nil {
+		t.Errorf("Import replace, count: %d, error: %v", count, err)
+	}
+	testExportKeys(t, other, "[k1 k2 ttl]")
+
+	// An invalid record imports nothing.
+	if _, err := other.Import(strings.NewReader(`{"key":"k3","value":""}`+"\n{bad"), ImportReplace); err == nil {
+		t.Errorf("Import invalid record did not return an error")
+	}
+	testExportKeys(t, other, "[k1 k2 ttl]")
+	if _, err := other.Import(strings.NewReader(""), ImportMode(9)); err == nil {
+		t.Errorf("Import invalid mode did not return an error")
+	}
+}
+
+func TestBackup(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+	if err := kvs.SetMany(map[string][]byte{"k1": []byte("v1"), "k2": []byte("v2")}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+
+	// Write while the backup is made.
+	done := make(chan struct{})
+	writer, err := New(dataSourceName+"?_busy_timeout=5000", "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer writer.Close()
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := writer.Set(fmt.Sprintf("w%d", i), []byte("w")); err != nil {
+				t.Errorf("Set, error: %v", err)
+				return
+			}
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := kvs.Backup(path); err != nil {
+		t.Errorf("Backup, error: %v", err)
+	}
+	<-done
+	if err := kvs.Backup(path); err == nil {
+		t.Errorf("Backup to existing file did not return an error")
+	}
+
+	restored, err := New(path, "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer restored.Close()
+	for _, key := range []string{"k1", "k2"} {
+		if b, err := restored.Get(key); string(b) != "v"+key[1:] || err != nil {
+			t.Errorf("Get, key: %s, value: %s, error: %v", key, b, err)
+		}
+	}
+}
+
+// testExportKeys checks the sorted keys of kvs.
+func testExportKeys(t *testing.T, kvs KVS, want string) {
+	kvps, err := kvs.Scan(nil)
+	keys := []string{}
+	for _, kv := range kvps {
+		keys = append(keys, kv.Key)
+	}
+	if err != nil || fmt.Sprint(keys) != want {
+		t.Errorf("keys: %v, want: %s, error: %v", keys, want, err)
+	}
+}
@@ -0,0 +1,262 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+const (
+	// fileOpSet, fileOpDelete, and fileOpSetNil are the operations of FileStore records;
+	// fileOpSetNil sets a nil value, which is not the same as an empty value.
+	fileOpSet    = 1
+	fileOpDelete = 2
+	fileOpSetNil = 3
+	// fileRecordHeaderLen is the length of the record header: payload length and CRC32.
+	fileRecordHeaderLen = 8
+)
+
+// FileStore is a Store that appends every change to a file, and keeps all keys and values
+// in memory; the file is read when the FileStore is created. A record that was partially
+// written, I.E. the process exited during a write, is detected by its CRC32 and discarded.
+// Use Compact to remove records for keys that were changed or deleted. It is safe for
+// concurrent use, but only one FileStore may use a file at a time.
+type FileStore struct {
+	file   *os.File
+	mutex  sync.RWMutex
+	path   string
+	values map[string][]byte
+}
+
+// NewFile creates a FileStore using the file at path; the file is created if it does not
+// exist.
+func NewFile(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("", err)
+	}
+	fs := FileStore{file: f, path: path, values: make(map[string][]byte)}
+	if err := fs.load(); err != nil {
+		if cerr := f.Close(); cerr != nil {
+			fmt.Printf("f.Close() error:%+v\n", cerr)
+		}
+		return nil, err
+	}
+	return &fs, nil
+}
+
+// Close closes the file.
+func (fs *FileStore) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.file.Close()
+}
+
+// Compact rewrites the file with only the current keys and values. The new file is written
+// and synced before it replaces the old file, so a failure leaves the old file intact.
+func (fs *FileStore) Compact() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	tmpPath := fs.path + ".compact"
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	w := bufio.NewWriter(f)
+	for key, value := range fs.values {
+		if _, err = w.Write(fileRecord(setOp(value), key, value)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fs.path)
+	}
+	if err != nil {
+		if cerr := f.Close(); cerr != nil {
+			fmt.Printf("f.Close() error:%+v\n", cerr)
+		}
+		if rerr := os.Remove(tmpPath); rerr != nil {
+			fmt.Printf("os.Remove() error:%+v\n", rerr)
+		}
+		return runtimeh.SourceInfoError("compacting", err)
+	}
+
+	if err := fs.file.Close(); err != nil {
+		fmt.Printf("fs.file.Close() error:%+v\n", err)
+	}
+	fs.file = f
+	return nil
+}
+
+// Delete deletes a key; returns the count, which is zero if the key did not exist.
+func (fs *FileStore) Delete(key string) (int64, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if _, ok := fs.values[key]; !ok {
+		return 0, nil
+	}
+	if err := fs.append(fileOpDelete, key, nil); err != nil {
+		return 0, err
+	}
+	delete(fs.values, key)
+	return 1, nil
+}
+
+// DeleteStore deletes all keys, and truncates the file.
+func (fs *FileStore) DeleteStore() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if err := fs.file.Truncate(0); err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	fs.values = make(map[string][]byte)
+	return nil
+}
+
+// Deserialize is KVS.Deserialize.
+func (fs *FileStore) Deserialize(key string, obj interface{}) error {
+	return deserialize(fs.Get, key, obj)
+}
+
+// Get gets a copy of the value of key; the value and error are both nil if the key does
+// not exist.
+func (fs *FileStore) Get(key string) ([]byte, error) {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	value, ok := fs.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyValue(value), nil
+}
+
+// Keys returns all keys, in no particular order.
+func (fs *FileStore) Keys() ([]string, error) {
+	fs.mutex.RLock()
+	defer fs.mutex.RUnlock()
+	keys := make([]string, 0, len(fs.values))
+	for key := range fs.values {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Serialize is KVS.Serialize.
+func (fs *FileStore) Serialize(key string, obj interface{}) error {
+	return serialize(fs.Set, nil, key, obj)
+}
+
+// Set sets a copy of value for key.
+func (fs *FileStore) Set(key string, value []byte) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if err := fs.append(setOp(value), key, value); err != nil {
+		return err
+	}
+	fs.values[key] = copyValue(value)
+	return nil
+}
+
+// append appends a record to the end of the file.
+func (fs *FileStore) append(op byte, key string, value []byte) error {
+	if _, err := fs.file.Seek(0, io.SeekEnd); err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	_, err := fs.file.Write(fileRecord(op, key, value))
+	return runtimeh.SourceInfoError("", err)
+}
+
+// load reads all records from the file. The file is truncated at the first record that is
+// incomplete or has an incorrect CRC32.
+func (fs *FileStore) load() error {
+	info, err := fs.file.Stat()
+	if err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	r := bufio.NewReader(fs.file)
+	var offset int64
+	header := make([]byte, fileRecordHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			break
+		}
+		payloadLen := int64(binary.LittleEndian.Uint32(header[0:4]))
+		if payloadLen > info.Size()-offset-fileRecordHeaderLen {
+			break
+		}
+		payload := make([]byte, payloadLen)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			break
+		}
+		op, key, value, ok := parseFileRecord(payload)
+		if !ok {
+			break
+		}
+		switch op {
+		case fileOpSet:
+			fs.values[key] = value
+		case fileOpSetNil:
+			fs.values[key] = nil
+		case fileOpDelete:
+			delete(fs.values, key)
+		}
+		offset += int64(fileRecordHeaderLen + len(payload))
+	}
+	return runtimeh.SourceInfoError("", fs.file.Truncate(offset))
+}
+
+// fileRecord returns a record: payload length, payload CRC32, and the payload, which is the
+// op, key length (uvarint), key, and value.
+func fileRecord(op byte, key string, value []byte) []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(value))
+	payload = append(payload, op)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+
+	record := make([]byte, fileRecordHeaderLen, fileRecordHeaderLen+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// setOp returns the operation of a record that sets value.
+func setOp(value []byte) byte {
+	if value == nil {
+		return fileOpSetNil
+	}
+	return fileOpSet
+}
+
+// parseFileRecord parses the payload of a record.
+func parseFileRecord(payload []byte) (byte, string, []byte, bool) {
+	if len(payload) < 1 {
+		return 0, "", nil, false
+	}
+	keyLen, n := binary.Uvarint(payload[1:])
+	if n <= 0 || keyLen > uint64(len(payload)-1-n) {
+		return 0, "", nil, false
+	}
+	key := payload[1+n : 1+n+int(keyLen)]
+	return payload[0], string(key), payload[1+n+int(keyLen):], true
+}
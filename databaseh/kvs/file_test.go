@@ -0,0 +1,129 @@
+package kvs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	fs, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile, error: %v", err)
+	}
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := fs.Set(key, []byte("v-"+key)); err != nil {
+			t.Errorf("Set, error: %v", err)
+		}
+	}
+	if err := fs.Set("k1", []byte("changed")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, err := fs.Delete("k2"); err != nil {
+		t.Errorf("Delete, error: %v", err)
+	}
+	// A nil value is not the same as an empty value.
+	if err := fs.Set("nil", nil); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := fs.Set("empty", []byte{}); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	fs.Close()
+
+	fs = testFileStoreValues(t, path, map[string]string{"k1": "changed", "k3": "v-k3", "nil": "", "empty": ""})
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat, error: %v", err)
+	}
+	sizeBefore := info.Size()
+	if err := fs.Compact(); err != nil {
+		t.Errorf("Compact, error: %v", err)
+	}
+	// The FileStore is usable after Compact.
+	if err := fs.Set("k4", []byte("v-k4")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	fs.Close()
+	if info, err := os.Stat(path); err != nil || info.Size() >= sizeBefore+int64(len(fileRecord(fileOpSet, "k4", []byte("v-k4")))) {
+		t.Errorf("file not compacted, before: %d, info: %+v, error: %v", sizeBefore, info, err)
+	}
+	fs = testFileStoreValues(t, path, map[string]string{"k1": "changed", "k3": "v-k3", "k4": "v-k4", "nil": "", "empty": ""})
+	defer fs.Close()
+	if b, err := fs.Get("nil"); b != nil || err != nil {
+		t.Errorf("Get nil value, value: %v, error: %v", b, err)
+	}
+	if b, err := fs.Get("empty"); b == nil || err != nil {
+		t.Errorf("Get empty value, value: %v, error: %v", b, err)
+	}
+}
+
+// TestFileStorePartialRecord shows that a partially written record is discarded.
+func TestFileStorePartialRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kvs")
+	fs, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile, error: %v", err)
+	}
+	if err := fs.Set("k1", []byte("v1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	fs.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat, error: %v", err)
+	}
+	validSize := info.Size()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile, error: %v", err)
+	}
+	record := fileRecord(fileOpSet, "k2", []byte("v2"))
+	if _, err := f.Write(record[:len(record)-1]); err != nil {
+		t.Fatalf("Write, error: %v", err)
+	}
+	f.Close()
+
+	fs = testFileStoreValues(t, path, map[string]string{"k1": "v1"})
+	if info, err := os.Stat(path); err != nil || info.Size() != validSize {
+		t.Errorf("partial record not truncated, info: %+v, error: %v", info, err)
+	}
+	// Records appended after the truncation are read.
+	if err := fs.Set("k3", []byte("v3")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	fs.Close()
+	testFileStoreValues(t, path, map[string]string{"k1": "v1", "k3": "v3"}).Close()
+
+	// A corrupt record is also discarded.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile, error: %v", err)
+	}
+	b[len(b)-1] ^= 0xff
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("WriteFile, error: %v", err)
+	}
+	testFileStoreValues(t, path, map[string]string{"k1": "v1"}).Close()
+}
+
+// testFileStoreValues opens the FileStore at path and checks it has only the keys and
+// values in want.
+func testFileStoreValues(t *testing.T, path string, want map[string]string) *FileStore {
+	fs, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile, error: %v", err)
+	}
+	keys, err := fs.Keys()
+	if err != nil || len(keys) != len(want) {
+		t.Errorf("Keys, keys: %v, want: %v, error: %v", keys, want, err)
+	}
+	for key, value := range want {
+		if b, err := fs.Get(key); string(b) != value || err != nil {
+			t.Errorf("Get, key: %s, value: %s, error: %v", key, b, err)
+		}
+	}
+	return fs
+}
@@ -0,0 +1,204 @@
+package kvs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// MetadataOrder is the order of the keys returned by QueryMetadata.
+type MetadataOrder int
+
+const (
+	// OrderByKey orders keys ascending.
+	OrderByKey MetadataOrder = iota
+	// OrderBySize orders keys by Size, largest first, then by key.
+	OrderBySize
+	// OrderByUpdated orders keys by UpdatedAt, most recent first, then by key.
+	OrderByUpdated
+)
+
+// Metadata describes a key, from Stat and QueryMetadata.
+type Metadata struct {
+	// ContentType is from SetWithContentType; it is empty for other writes.
+	ContentType string
+	// CreatedAt is when the key was created; it is zero for keys written before metadata was
+	// recorded. Setting an existing key keeps CreatedAt.
+	CreatedAt time.Time
+	// ExpiresAt is when the key expires; it is zero if the key does not expire.
+	ExpiresAt time.Time
+	Key       string
+	// Size is the size of the value as stored; with a Keyring this is the size of the
+	// encrypted value, which is larger than the value.
+	Size int64
+	// UpdatedAt is when the value was last set; it is zero for keys written before metadata
+	// was recorded.
+	UpdatedAt time.Time
+	Version   int64
+}
+
+// MetadataQuery selects, and orders, the keys returned by QueryMetadata. All conditions are
+// combined. Expired keys are not returned.
+type MetadataQuery struct {
+	// ContentType, when not empty, only returns keys with the content type.
+	ContentType string
+	// Limit, when greater than zero, is the maximum number of keys returned.
+	Limit int
+	// MinSize only returns keys with a Size of at least MinSize.
+	MinSize int64
+	OrderBy MetadataOrder
+	// Prefix, when not empty, only returns keys that start with Prefix.
+	Prefix string
+	// UpdatedSince, when not zero, only returns keys updated at or after UpdatedSince.
+	UpdatedSince time.Time
+}
+
+// metadataColumns are the columns scanned by scanMetadata.
+const metadataColumns = `key, content_type, created_at, expires_at, length(value), updated_at, version`
+
+// QueryMetadata returns the metadata of the keys selected by query; I.E. the keys updated in
+// the last hour, or the largest keys. A nil query returns all keys, ordered by key.
+func (kvs KVS) QueryMetadata(query *MetadataQuery) ([]Metadata, error) {
+	if query == nil {
+		query = &MetadataQuery{}
+	}
+	if kvs.dbConn == nil {
+		return nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	conditions := []string{notExpired}
+	args := []interface{}{time.Now().UnixNano()}
+	addCondition := func(condition string, arg interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if query.ContentType != "" {
+		addCondition("content_type = ?", query.ContentType)
+	}
+	if query.MinSize > 0 {
+		addCondition("length(value) >= ?", query.MinSize)
+	}
+	if query.Prefix != "" {
+		addCondition("key >= ?", query.Prefix)
+		if end := prefixEnd(query.Prefix); end != "" {
+			addCondition("key < ?", end)
+		}
+	}
+	if !query.UpdatedSince.IsZero() {
+		addCondition("updated_at >= ?", query.UpdatedSince.UnixNano())
+	}
+
+	var order string
+	switch query.OrderBy {
+	case OrderByKey:
+		order = "key"
+	case OrderBySize:
+		order = "length(value) DESC, key"
+	case OrderByUpdated:
+		order = "updated_at DESC, key"
+	default:
+		return nil, fmt.Errorf("%s invalid order: %d", runtimeh.SourceInfo(), query.OrderBy)
+	}
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", metadataColumns, kvs.table,
+		strings.Join(conditions, " AND "), order)
+	if query.Limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := kvs.readConn.Query(q+";", args...)
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("rows.Close() error:%+v\n", err)
+		}
+	}()
+	metadata := []Metadata{}
+	for rows.Next() {
+		md, err := scanMetadata(rows)
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, md)
+	}
+	return metadata, runtimeh.SourceInfoError("scan iteration error", rows.Err())
+}
+
+// SetWithContentType is Set, and also sets the content type of the value; I.E. a MIME type,
+// or any tag. The content type is returned by Stat, and can be used with QueryMetadata.
+func (kvs KVS) SetWithContentType(key string, value []byte, contentType string) error {
+	if kvs.dbConn == nil {
+		return fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	err := kvs.set(kvs.dbConn, key, value, nil, contentType)
+	kvs.cache.remove(key)
+	return err
+}
+
+// Stat returns the metadata of key, without reading the value into memory; it returns nil if
+// the key does not exist.
+func (kvs KVS) Stat(key string) (*Metadata, error) {
+	if kvs.dbConn == nil {
+		return nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	row := kvs.readConn.QueryRow(fmt.Sprintf(`SELECT %s FROM %s WHERE key=? AND %s;`, metadataColumns, kvs.table, notExpired),
+		key, time.Now().UnixNano())
+	md, err := scanMetadata(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &md, nil
+}
+
+// SetWithContentType is KVS.SetWithContentType in the transaction.
+func (tx *Tx) SetWithContentType(key string, value []byte, contentType string) error {
+	tx.invalidate(key)
+	return tx.kvs.set(tx.tx, key, value, nil, contentType)
+}
+
+// nullString returns nil, which is NULL in the database, for an empty string.
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// scanMetadata scans a row of metadataColumns; sql.ErrNoRows is returned unwrapped.
+func scanMetadata(row interface {
+	Scan(dest ...interface{}) error
+}) (Metadata, error) {
+	var contentType sql.NullString
+	var createdAt, expiresAt, size, updatedAt sql.NullInt64
+	md := Metadata{}
+	err := row.Scan(&md.Key, &contentType, &createdAt, &expiresAt, &size, &updatedAt, &md.Version)
+	if err == sql.ErrNoRows {
+		return md, err
+	}
+	if err != nil {
+		return md, runtimeh.SourceInfoError("scan error", err)
+	}
+	md.ContentType = contentType.String
+	md.CreatedAt = unixNanoTime(createdAt)
+	md.ExpiresAt = unixNanoTime(expiresAt)
+	md.Size = size.Int64
+	md.UpdatedAt = unixNanoTime(updatedAt)
+	return md, nil
+}
+
+// unixNanoTime returns the time of Unix nanoseconds, or the zero time for NULL.
+func unixNanoTime(n sql.NullInt64) time.Time {
+	if !n.Valid {
+		return time.Time{}
+	}
+	return time.Unix(0, n.Int64)
+}
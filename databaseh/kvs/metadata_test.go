@@ -0,0 +1,134 @@
+package kvs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStat(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	if md, err := kvs.Stat("k1"); md != nil || err != nil {
+		t.Errorf("Stat missing key, metadata: %+v, error: %v", md, err)
+	}
+	before := time.Now()
+	if err := kvs.SetWithContentType("k1", []byte(`{"a":1}`), "application/json"); err != nil {
+		t.Errorf("SetWithContentType, error: %v", err)
+		return
+	}
+	created, err := kvs.Stat("k1")
+	if err != nil || created == nil {
+		t.Errorf("Stat, metadata: %+v, error: %v", created, err)
+		return
+	}
+	if created.ContentType != "application/json" || created.Key != "k1" || created.Size != 7 || created.Version != 1 ||
+		created.CreatedAt.Before(before) || !created.UpdatedAt.Equal(created.CreatedAt) || !created.ExpiresAt.IsZero() {
+		t.Errorf("Stat, metadata: %+v", created)
+	}
+
+	// Set keeps the created time, and removes the content type.
+	time.Sleep(time.Millisecond)
+	if err := kvs.Set("k1", []byte("v2")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	md, err := kvs.Stat("k1")
+	if err != nil || md == nil || md.ContentType != "" || md.Size != 2 || md.Version != 2 ||
+		!md.CreatedAt.Equal(created.CreatedAt) || !md.UpdatedAt.After(created.UpdatedAt) {
+		t.Errorf("Stat after Set, metadata: %+v, error: %v", md, err)
+	}
+
+	if err := kvs.SetWithTTL("k2", []byte("v2"), time.Hour); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	if md, err := kvs.Stat("k2"); err != nil || md == nil || md.ExpiresAt.Before(before.Add(time.Hour)) {
+		t.Errorf("Stat with TTL, metadata: %+v, error: %v", md, err)
+	}
+	if _, err := kvs.CompareAndSwap("k2", 1, []byte("v3")); err != nil {
+		t.Errorf("CompareAndSwap, error: %v", err)
+	}
+	if md, err := kvs.Stat("k2"); err != nil || md == nil || md.Version != 2 || !md.ExpiresAt.IsZero() {
+		t.Errorf("Stat after CompareAndSwap, metadata: %+v, error: %v", md, err)
+	}
+}
+
+func TestQueryMetadata(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	if err := kvs.Set("a/1", []byte("1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := kvs.SetWithContentType("a/333", []byte("333"), "text/plain"); err != nil {
+		t.Errorf("SetWithContentType, error: %v", err)
+	}
+	if err := kvs.SetWithTTL("expired", []byte("expired"), time.Nanosecond); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	since := time.Now()
+	if err := kvs.Update(func(tx *Tx) error {
+		return tx.SetWithContentType("b/22", []byte("22"), "text/plain")
+	}); err != nil {
+		t.Errorf("Update, error: %v", err)
+	}
+
+	tests := []struct {
+		query *MetadataQuery
+		want  []string
+	}{
+		{nil, []string{"a/1", "a/333", "b/22"}},
+		{&MetadataQuery{OrderBy: OrderBySize}, []string{"a/333", "b/22", "a/1"}},
+		{&MetadataQuery{OrderBy: OrderBySize, Limit: 1}, []string{"a/333"}},
+		{&MetadataQuery{OrderBy: OrderByUpdated}, []string{"b/22", "a/333", "a/1"}},
+		{&MetadataQuery{UpdatedSince: since}, []string{"b/22"}},
+		{&MetadataQuery{ContentType: "text/plain"}, []string{"a/333", "b/22"}},
+		{&MetadataQuery{MinSize: 2, Prefix: "a/"}, []string{"a/333"}},
+	}
+	for i, test := range tests {
+		metadata, err := kvs.QueryMetadata(test.query)
+		if err != nil {
+			t.Errorf("QueryMetadata, test: %d, error: %v", i, err)
+			continue
+		}
+		keys := []string{}
+		for _, md := range metadata {
+			keys = append(keys, md.Key)
+		}
+		if len(keys) != len(test.want) {
+			t.Errorf("QueryMetadata, test: %d, keys: %v, want: %v", i, keys, test.want)
+			continue
+		}
+		for j := range keys {
+			if keys[j] != test.want[j] {
+				t.Errorf("QueryMetadata, test: %d, keys: %v, want: %v", i, keys, test.want)
+				break
+			}
+		}
+	}
+
+	if _, err := kvs.QueryMetadata(&MetadataQuery{OrderBy: -1}); err == nil {
+		t.Errorf("QueryMetadata with invalid order did not return an error")
+	}
+}
@@ -0,0 +1,318 @@
+package kvs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/paulfdunn/go-helper/databaseh/v2"
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// Namespaces manages the stores, I.E. the KVS tables, in a database. Store names are
+// validated, and quoted in queries, so any name accepted by databaseh.ValidateIdentifier
+// can be used to create a store, except names ending with "_changes", which are used for
+// change logs, databaseh.MigrationsTable, and kvs_revisions. Existing stores, with other
+// names, can be listed, dropped, copied, and renamed, as with New.
+type Namespaces struct {
+	dbConn *sql.DB
+}
+
+// StoreInfo describes a store, from Namespaces.List.
+type StoreInfo struct {
+	// Bytes is the size of all keys and values; it does not include SQLite page and index
+	// overhead.
+	Bytes int64
+	Name  string
+	// Rows is the count of keys, including expired keys that have not been deleted.
+	Rows int64
+}
+
+// NewNamespaces opens the database for managing stores; a nil options uses the defaults.
+func NewNamespaces(dbConnectionString string, options *databaseh.Options) (Namespaces, error) {
+	dbConn, err := databaseh.OpenWithOptions(dbConnectionString, options)
+	if err != nil {
+		return Namespaces{}, runtimeh.SourceInfoError("opening db", err)
+	}
+	return Namespaces{dbConn: dbConn}, nil
+}
+
+// Close closes the database connection.
+func (ns Namespaces) Close() error {
+	return ns.dbConn.Close()
+}
+
+// Copy creates the store dst with a copy of all keys, values, expiry times, and versions
+// of the store src; it is an error if dst exists. The change log is not copied.
+func (ns Namespaces) Copy(src string, dst string) error {
+	return ns.update(func(tx *sql.Tx) error {
+		if err := ns.checkStores(tx, src, dst); err != nil {
+			return err
+		}
+		// src is migrated, if needed, so it has the same columns as dst.
+		for _, table := range []string{src, dst} {
+			if _, err := databaseh.MigrateTx(tx, schemaName(table), migrations(table)); err != nil {
+				return err
+			}
+		}
+		columns, err := tableColumns(tx, dst)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM %s;`,
+			databaseh.QuoteIdentifier(dst), columns, columns, databaseh.QuoteIdentifier(src))); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+		return copyRevision(tx, src, dst)
+	})
+}
+
+// Create creates the store, if it does not exist, as New.
+func (ns Namespaces) Create(name string) error {
+	if err := validateTable(name); err != nil {
+		return err
+	}
+	_, err := databaseh.Migrate(ns.dbConn, schemaName(name), migrations(name))
+	return err
+}
+
+// Drop drops the store, and its change log, as KVS.DeleteStore; it is not an error if the
+// store does not exist.
+func (ns Namespaces) Drop(name string) error {
+	return ns.update(func(tx *sql.Tx) error {
+		if err := checkTable(tx, name); err != nil {
+			return err
+		}
+		return dropStore(tx, name)
+	})
+}
+
+// List returns all stores, sorted by name; a store is a table with key and value columns.
+func (ns Namespaces) List() ([]StoreInfo, error) {
+	rows, err := ns.dbConn.Query(`SELECT m.name FROM sqlite_master m WHERE m.type='table'
+		AND (SELECT COUNT(*) FROM pragma_table_info(m.name) WHERE name IN ('key', 'value')) = 2
+		ORDER BY m.name;`)
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("", err)
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, runtimeh.SourceInfoError("scan error", err)
+		}
+		names = append(names, name)
+	}
+	err = rows.Err()
+	if cerr := rows.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("scan iteration error", err)
+	}
+
+	stores := make([]StoreInfo, 0, len(names))
+	for _, name := range names {
+		// Change logs have key and value columns, but are not stores.
+		if checkTable(ns.dbConn, name) != nil {
+			continue
+		}
+		info := StoreInfo{Name: name}
+		row := ns.dbConn.QueryRow(fmt.Sprintf(`SELECT COUNT(*),
+			COALESCE(SUM(length(CAST(key AS BLOB)) + COALESCE(length(value), 0)), 0) FROM %s;`,
+			databaseh.QuoteIdentifier(name)))
+		if err := row.Scan(&info.Rows, &info.Bytes); err != nil {
+			return nil, runtimeh.SourceInfoError("scan error", err)
+		}
+		stores = append(stores, info)
+	}
+	return stores, nil
+}
+
+// Rename renames the store oldName to newName, with its change log; it is an error if
+// newName exists. A KVS, or Watch, using oldName must be closed first, and opened with
+// newName.
+func (ns Namespaces) Rename(oldName string, newName string) error {
+	return ns.update(func(tx *sql.Tx) error {
+		if err := ns.checkStores(tx, oldName, newName); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s RENAME TO %s;`,
+			databaseh.QuoteIdentifier(oldName), databaseh.QuoteIdentifier(newName))); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+		if err := databaseh.RenameSchema(tx, schemaName(oldName), schemaName(newName)); err != nil {
+			return err
+		}
+		if err := renameRevisions(tx, oldName, newName); err != nil {
+			return err
+		}
+
+		_, isChangeLog, err := findChangeLog(tx, oldName)
+		if err != nil || !isChangeLog {
+			return err
+		}
+		// The index and triggers are named after the change log, so they are recreated.
+		index, triggers := changeTriggerNames(oldName)
+		queries := []string{fmt.Sprintf(`DROP INDEX IF EXISTS %s;`, databaseh.QuoteIdentifier(index))}
+		for _, trigger := range triggers {
+			queries = append(queries, fmt.Sprintf(`DROP TRIGGER IF EXISTS %s;`, databaseh.QuoteIdentifier(trigger)))
+		}
+		queries = append(queries, fmt.Sprintf(`ALTER TABLE %s RENAME TO %s;`,
+			databaseh.QuoteIdentifier(changeTableName(oldName)), databaseh.QuoteIdentifier(changeTableName(newName))))
+		for _, query := range queries {
+			if _, err := tx.Exec(query); err != nil {
+				return runtimeh.SourceInfoError("", err)
+			}
+		}
+		return createChangeLog(tx, newName)
+	})
+}
+
+// checkStores validates src, as New, and dst, as Create, and returns an error if src does not
+// exist or dst exists.
+func (ns Namespaces) checkStores(q querier, src string, dst string) error {
+	if err := checkTable(q, src); err != nil {
+		return err
+	}
+	if err := validateTable(dst); err != nil {
+		return err
+	}
+	if exists, err := tableExists(q, src); err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("%s store does not exist: %s", runtimeh.SourceInfo(), src)
+		}
+		return err
+	}
+	for _, table := range []string{dst, changeTableName(dst)} {
+		if exists, err := tableExists(q, table); err != nil || exists {
+			if err == nil {
+				err = fmt.Errorf("%s store exists: %s", runtimeh.SourceInfo(), dst)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// update calls fn with a transaction, which is committed if fn returns nil, and otherwise
+// rolled back.
+func (ns Namespaces) update(fn func(tx *sql.Tx) error) error {
+	if ns.dbConn == nil {
+		return fmt.Errorf("%s namespaces db is nil", runtimeh.SourceInfo())
+	}
+	tx, err := ns.dbConn.Begin()
+	if err != nil {
+		return runtimeh.SourceInfoError("begin transaction", err)
+	}
+	if err := fn(tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			fmt.Printf("tx.Rollback() error:%+v\n", rerr)
+		}
+		return err
+	}
+	return runtimeh.SourceInfoError("commit transaction", tx.Commit())
+}
+
+// dropStore drops the table, and its change log, and deletes the recorded schema version. The
+// highest version is kept, so versions are not reused if the store is created again.
+func dropStore(tx *sql.Tx, table string) error {
+	// A table with the name of the change log, that is not a change log, is a store, so it
+	// is not dropped.
+	_, isChangeLog, err := findChangeLog(tx, table)
+	if err != nil {
+		return err
+	}
+	if err := recordDropped(tx, table); err != nil {
+		return err
+	}
+	tables := []string{table}
+	if isChangeLog {
+		tables = append(tables, changeTableName(table))
+	}
+	// Dropping the table also drops the change log triggers, from Watch.
+	for _, t := range tables {
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, databaseh.QuoteIdentifier(t))); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+	}
+	return databaseh.DeleteSchema(tx, schemaName(table))
+}
+
+// tableColumns returns the quoted column names of the table, separated by commas.
+func tableColumns(q querier, table string) (string, error) {
+	rows, err := q.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid;`, table)
+	if err != nil {
+		return "", runtimeh.SourceInfoError("", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("rows.Close() error:%+v\n", err)
+		}
+	}()
+	columns := []string{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return "", runtimeh.SourceInfoError("scan error", err)
+		}
+		columns = append(columns, databaseh.QuoteIdentifier(column))
+	}
+	return strings.Join(columns, ", "), runtimeh.SourceInfoError("scan iteration error", rows.Err())
+}
+
+// checkTable returns an error if table can not be used by New: the name is empty, not UTF-8,
+// or contains a NUL, or it is a table this package creates, databaseh.MigrationsTable,
+// kvs_revisions, or the change log of an existing store. Other names are not reserved, unlike validateTable, so
+// stores created before names were validated can still be opened; such a store prevents
+// Watch on the store whose change log has its name.
+func checkTable(q querier, table string) error {
+	if table == "" || !utf8.ValidString(table) || strings.ContainsRune(table, 0) {
+		return fmt.Errorf("%s table name is empty, not valid UTF-8, or contains a NUL, name: %q", runtimeh.SourceInfo(), table)
+	}
+	if strings.EqualFold(table, databaseh.MigrationsTable) || strings.EqualFold(table, revisionsTable) {
+		return fmt.Errorf("%s table name is reserved, name: %q", runtimeh.SourceInfo(), table)
+	}
+	if len(table) > len(changeTableSuffix) && strings.HasSuffix(strings.ToLower(table), changeTableSuffix) {
+		store := table[:len(table)-len(changeTableSuffix)]
+		exists, err := tableExists(q, store)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if changesExists, isChangeLog, err := findChangeLog(q, store); err != nil || (changesExists && !isChangeLog) {
+				return err
+			}
+			return fmt.Errorf("%s table name is the change log of store %q, name: %q", runtimeh.SourceInfo(), store, table)
+		}
+	}
+	return nil
+}
+
+// tableExists returns true if the table exists; names are compared as SQLite does, without
+// case.
+func tableExists(q querier, table string) (bool, error) {
+	var count int
+	row := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE;`, table)
+	if err := row.Scan(&count); err != nil {
+		return false, runtimeh.SourceInfoError("", err)
+	}
+	return count > 0, nil
+}
+
+// validateTable returns an error if the table name is not valid for a new store, from
+// Namespaces.Create.
+func validateTable(table string) error {
+	if err := databaseh.ValidateIdentifier(table); err != nil {
+		return err
+	}
+	if strings.EqualFold(table, databaseh.MigrationsTable) || strings.EqualFold(table, revisionsTable) {
+		return fmt.Errorf("%s table name is reserved, name: %q", runtimeh.SourceInfo(), table)
+	}
+	if strings.HasSuffix(strings.ToLower(table), changeTableSuffix) {
+		return fmt.Errorf("%s table name ending with %s is reserved, name: %q", runtimeh.SourceInfo(), changeTableSuffix, table)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paulfdunn/go-helper/databaseh/v2"
+)
+
+func TestTableNames(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	// Names that need quoting, including an attempt at SQL injection, are tables.
+	for _, table := range []string{"with-dash", "with space", `quote"d`, "t; DROP TABLE other; --"} {
+		kvs, err := New(dataSourceName, table)
+		if err != nil {
+			t.Errorf("New, table: %s, error: %v", table, err)
+			continue
+		}
+		if err := kvs.Set("k1", []byte("v1")); err != nil {
+			t.Errorf("Set, table: %s, error: %v", table, err)
+		}
+		if b, err := kvs.Get("k1"); string(b) != "v1" || err != nil {
+			t.Errorf("Get, table: %s, value: %s, error: %v", table, b, err)
+		}
+		if _, stop, err := kvs.Watch("", time.Millisecond); err != nil {
+			t.Errorf("Watch, table: %s, error: %v", table, err)
+		} else {
+			stop()
+		}
+		if err := kvs.DeleteStore(); err != nil {
+			t.Errorf("DeleteStore, table: %s, error: %v", table, err)
+		}
+		kvs.Close()
+	}
+
+	// Only names that can not be quoted, the migrations table, and the change log of an
+	// existing store, are rejected by New; SQLite rejects creating tables named sqlite_.
+	kvs, err := New(dataSourceName, "t")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	kvs.Close()
+	for _, table := range []string{"", "t\x00", databaseh.MigrationsTable, "t_changes", "T_CHANGES", "SQLITE_x"} {
+		if _, err := New(dataSourceName, table); err == nil {
+			t.Errorf("New with invalid table did not return an error, table: %q", table)
+		}
+	}
+
+	// A store ending with _changes, for which there is no store without the suffix, can be
+	// opened, but not created with Namespaces.
+	kvs, err = New(dataSourceName, "other_changes")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+	if err := kvs.Set("k1", []byte("v1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	ns, err := NewNamespaces(dataSourceName, nil)
+	if err != nil {
+		t.Fatalf("NewNamespaces, error: %v", err)
+	}
+	defer ns.Close()
+	if err := ns.Create("new_changes"); err == nil {
+		t.Errorf("Create with reserved name did not return an error")
+	}
+	if stores, err := ns.List(); err != nil || len(stores) != 2 || stores[0].Name != "other_changes" || stores[1].Name != "t" {
+		t.Errorf("List, stores: %+v, error: %v", stores, err)
+	}
+
+	// The store other can be opened, but not watched, as its change log would be the store
+	// other_changes, which is not dropped with other.
+	other, err := New(dataSourceName, "other")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer other.Close()
+	if _, _, err := other.Watch("", time.Millisecond); err == nil {
+		t.Errorf("Watch with a store named as the change log did not return an error")
+	}
+	if changes, err := New(dataSourceName, "other_changes"); err != nil {
+		t.Errorf("New of a store named as the change log of an existing store, error: %v", err)
+	} else {
+		changes.Close()
+	}
+	if err := other.DeleteStore(); err != nil {
+		t.Errorf("DeleteStore, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); string(b) != "v1" || err != nil {
+		t.Errorf("Get after DeleteStore of other, value: %s, error: %v", b, err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	ns, err := NewNamespaces(dataSourceName, nil)
+	if err != nil {
+		t.Fatalf("NewNamespaces, error: %v", err)
+	}
+	defer ns.Close()
+	if err := ns.Create("empty"); err != nil {
+		t.Errorf("Create, error: %v", err)
+	}
+	kvs, err := New(dataSourceName, "my-store")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	if err := kvs.SetMany(map[string][]byte{"k1": []byte("v1"), "k22": []byte("v22")}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+	// The change log is not a store.
+	_, stop, err := kvs.Watch("", time.Millisecond)
+	if err != nil {
+		t.Errorf("Watch, error: %v", err)
+	}
+	stop()
+	kvs.Close()
+
+	testNamespacesList(t, ns, "[{Bytes:0 Name:empty Rows:0} {Bytes:10 Name:my-store Rows:2}]")
+
+	if err := ns.Copy("my-store", "copy"); err != nil {
+		t.Errorf("Copy, error: %v", err)
+	}
+	if err := ns.Copy("my-store", "empty"); err == nil {
+		t.Errorf("Copy to existing store did not return an error")
+	}
+	if err := ns.Copy("missing", "other"); err == nil {
+		t.Errorf("Copy missing store did not return an error")
+	}
+	if err := ns.Rename("my-store", "renamed"); err != nil {
+		t.Errorf("Rename, error: %v", err)
+	}
+	if err := ns.Rename("renamed", "copy"); err == nil {
+		t.Errorf("Rename to existing store did not return an error")
+	}
+	if err := ns.Drop("empty"); err != nil {
+		t.Errorf("Drop, error: %v", err)
+	}
+	if err := ns.Drop("sqlite_master"); err == nil {
+		t.Errorf("Drop invalid name did not return an error")
+	}
+	testNamespacesList(t, ns, "[{Bytes:10 Name:copy Rows:2} {Bytes:10 Name:renamed Rows:2}]")
+	for name, want := range map[string]int{"copy": len(migrations("")), "renamed": len(migrations("")), "my-store": 0, "empty": 0} {
+		if version, err := databaseh.SchemaVersion(ns.dbConn, schemaName(name)); version != want || err != nil {
+			t.Errorf("SchemaVersion, name: %s, version: %d, error: %v", name, version, err)
+		}
+	}
+
+	// The renamed store, and its change log, are used with the new name.
+	kvs, err = New(dataSourceName, "renamed")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+	if _, v, err := kvs.GetWithVersion("k1"); v != 1 || err != nil {
+		t.Errorf("GetWithVersion, version: %d, error: %v", v, err)
+	}
+	events, stop, err := kvs.Watch("", time.Millisecond)
+	if err != nil {
+		t.Fatalf("Watch, error: %v", err)
+	}
+	defer stop()
+	if err := kvs.Set("k1", []byte("changed")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Key != "k1" || event.Version != 2 {
+			t.Errorf("event: %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timeout waiting for event")
+	}
+	// The change log triggers, and the trigger that records deleted versions.
+	var count int
+	if err := kvs.dbConn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='trigger' AND tbl_name=?;`,
+		kvs.name).Scan(&count); err != nil || count != 4 {
+		t.Errorf("triggers, count: %d, error: %v", count, err)
+	}
+}
+
+// testNamespacesList checks the stores from List.
+func testNamespacesList(t *testing.T, ns Namespaces, want string) {
+	stores, err := ns.List()
+	if err != nil || fmt.Sprintf("%+v", stores) != want {
+		t.Errorf("List, stores: %+v, want: %s, error: %v", stores, want, err)
+	}
+}
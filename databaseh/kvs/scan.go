@@ -0,0 +1,215 @@
+package kvs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// Iterator iterates over the keys, and optionally values, of a scan, reading rows from the
+// database as needed rather than buffering all of them. Like sql.Rows, call Next before
+// each KeyValue, check Err after Next returns false, and call Close when done. The
+// Iterator holds a database connection until it is closed.
+type Iterator struct {
+	err     error
+	keyring *Keyring
+	kv      KeyValue
+	rows    *sql.Rows
+	values  bool
+}
+
+// KeyValue is a key, and the value when ScanOptions.Values is set.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// ScanOptions select, and order, the keys returned by Scan, Iterate, and All. All
+// conditions are combined, and keys are returned in ascending order. Expired keys are
+// not returned.
+type ScanOptions struct {
+	// After is a cursor for pagination; only keys greater than After are returned. Use the
+	// last key of the previous page. Unlike Offset, this does not skip rows in the database.
+	After string
+	// End, when not empty, is the exclusive upper bound of keys.
+	End string
+	// Limit, when greater than zero, is the maximum number of keys returned.
+	Limit int
+	// Offset is the number of keys skipped.
+	Offset int
+	// Prefix, when not empty, only returns keys that start with Prefix.
+	Prefix string
+	// Start, when not empty, is the inclusive lower bound of keys.
+	Start string
+	// Values returns values as well as keys.
+	Values bool
+}
+
+// All returns an iterator function over the keys of a scan; with Go 1.23 or later it can
+// be used with range: for kv, err := range kvs.All(options). Iteration stops after an
+// error is returned. A nil options returns all keys.
+func (kvs KVS) All(options *ScanOptions) func(yield func(KeyValue, error) bool) {
+	return func(yield func(KeyValue, error) bool) {
+		it := kvs.Iterate(options)
+		defer func() {
+			if err := it.Close(); err != nil {
+				fmt.Printf("it.Close() error:%+v\n", err)
+			}
+		}()
+		for it.Next() {
+			if !yield(it.KeyValue(), nil) {
+				return
+			}
+		}
+		if err := it.Err(); err != nil {
+			yield(KeyValue{}, err)
+		}
+	}
+}
+
+// Iterate returns an Iterator over the keys of a scan. A nil options returns all keys.
+// Errors are returned by Iterator.Err.
+func (kvs KVS) Iterate(options *ScanOptions) *Iterator {
+	if options == nil {
+		options = &ScanOptions{}
+	}
+	if kvs.dbConn == nil {
+		return &Iterator{err: fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())}
+	}
+
+	query, args := scanQuery(kvs.table, options)
+	rows, err := kvs.readConn.Query(query, args...)
+	if err != nil {
+		return &Iterator{err: runtimeh.SourceInfoError("", err)}
+	}
+	return &Iterator{keyring: kvs.keyring, rows: rows, values: options.Values}
+}
+
+// Scan returns the keys of a scan; use Limit and After, or Offset, to return a page of keys.
+// A nil options returns all keys.
+func (kvs KVS) Scan(options *ScanOptions) ([]KeyValue, error) {
+	it := kvs.Iterate(options)
+	defer func() {
+		if err := it.Close(); err != nil {
+			fmt.Printf("it.Close() error:%+v\n", err)
+		}
+	}()
+
+	page := []KeyValue{}
+	for it.Next() {
+		page = append(page, it.KeyValue())
+	}
+	return page, it.Err()
+}
+
+// Close closes the Iterator; it is safe to call Close more than once.
+func (it *Iterator) Close() error {
+	if it.rows == nil {
+		return nil
+	}
+	err := it.rows.Close()
+	it.rows = nil
+	return err
+}
+
+// Err returns the error, if any, that occurred during iteration.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// KeyValue returns the current key, and value.
+func (it *Iterator) KeyValue() KeyValue {
+	return it.kv
+}
+
+// Next advances to the next key; it returns false when there are no more keys, or an
+// error occurred. The Iterator is closed when Next returns false.
+func (it *Iterator) Next() bool {
+	if it.rows == nil {
+		return false
+	}
+	if !it.rows.Next() {
+		if err := it.rows.Err(); err != nil {
+			it.err = runtimeh.SourceInfoError("scan iteration error", err)
+		}
+		if err := it.Close(); err != nil && it.err == nil {
+			it.err = runtimeh.SourceInfoError("", err)
+		}
+		return false
+	}
+
+	it.kv = KeyValue{}
+	var err error
+	if it.values {
+		if err = it.rows.Scan(&it.kv.Key, &it.kv.Value); err == nil {
+			it.kv.Value, err = it.keyring.open(it.kv.Key, it.kv.Value)
+		}
+	} else {
+		err = it.rows.Scan(&it.kv.Key)
+	}
+	if err != nil {
+		it.err = runtimeh.SourceInfoError("scan error", err)
+		if err := it.Close(); err != nil {
+			fmt.Printf("it.Close() error:%+v\n", err)
+		}
+		return false
+	}
+	return true
+}
+
+// prefixEnd returns the smallest string greater than all strings starting with prefix, or
+// an empty string if there is none; I.E. "user/" returns "user0".
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// scanQuery returns the query, and arguments, for a scan.
+func scanQuery(table string, options *ScanOptions) (string, []interface{}) {
+	columns := "key"
+	if options.Values {
+		columns = "key, value"
+	}
+	conditions := []string{notExpired}
+	args := []interface{}{time.Now().UnixNano()}
+	addCondition := func(condition string, arg string) {
+		conditions = append(conditions, condition)
+		args = append(args, arg)
+	}
+	if options.Prefix != "" {
+		addCondition("key >= ?", options.Prefix)
+		if end := prefixEnd(options.Prefix); end != "" {
+			addCondition("key < ?", end)
+		}
+	}
+	if options.Start != "" {
+		addCondition("key >= ?", options.Start)
+	}
+	if options.End != "" {
+		addCondition("key < ?", options.End)
+	}
+	if options.After != "" {
+		addCondition("key > ?", options.After)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY key", columns, table, strings.Join(conditions, " AND "))
+	if options.Limit > 0 || options.Offset > 0 {
+		// A negative LIMIT is no limit, which SQLite requires for an OFFSET without a limit.
+		limit := options.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, options.Offset)
+	}
+	return query + ";", args
+}
@@ -0,0 +1,158 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	kvs := scanSetup(t)
+	defer kvs.Close()
+
+	tests := []struct {
+		options *ScanOptions
+		want    []string
+	}{
+		{nil, []string{"0012", "1", "10", "100", "1a", "1e3", "2", "a", "user/1/name", "user/1/settings", "user/2/name",
+			"user/3/name", "users"}},
+		{&ScanOptions{Prefix: "1"}, []string{"1", "10", "100", "1a", "1e3"}},
+		{&ScanOptions{Start: "1", End: "2"}, []string{"1", "10", "100", "1a", "1e3"}},
+		{&ScanOptions{Prefix: "user/"}, []string{"user/1/name", "user/1/settings", "user/2/name", "user/3/name"}},
+		{&ScanOptions{Prefix: "user/1/"}, []string{"user/1/name", "user/1/settings"}},
+		{&ScanOptions{Start: "user/2", End: "users"}, []string{"user/2/name", "user/3/name"}},
+		{&ScanOptions{Prefix: "user/", Limit: 2}, []string{"user/1/name", "user/1/settings"}},
+		{&ScanOptions{Prefix: "user/", Limit: 2, Offset: 2}, []string{"user/2/name", "user/3/name"}},
+		{&ScanOptions{Offset: 12}, []string{"users"}},
+		{&ScanOptions{Prefix: "user/", After: "user/1/settings"}, []string{"user/2/name", "user/3/name"}},
+		{&ScanOptions{Prefix: "none"}, []string{}},
+	}
+	for _, test := range tests {
+		page, err := kvs.Scan(test.options)
+		keys := []string{}
+		for _, kv := range page {
+			keys = append(keys, kv.Key)
+			if kv.Value != nil {
+				t.Errorf("value returned without Values")
+			}
+		}
+		if err != nil || !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("options: %+v, keys: %v, want: %v, error: %v", test.options, keys, test.want, err)
+		}
+	}
+
+	page, err := kvs.Scan(&ScanOptions{Prefix: "user/2", Values: true})
+	if err != nil || len(page) != 1 || string(page[0].Value) != "v-user/2/name" {
+		t.Errorf("Scan with Values, page: %+v, error: %v", page, err)
+	}
+}
+
+// TestScanPages pages through all keys with a cursor.
+func TestScanPages(t *testing.T) {
+	kvs := scanSetup(t)
+	defer kvs.Close()
+
+	keys := []string{}
+	options := &ScanOptions{Limit: 4}
+	for {
+		page, err := kvs.Scan(options)
+		if err != nil {
+			t.Errorf("Scan, error: %v", err)
+			return
+		}
+		for _, kv := range page {
+			keys = append(keys, kv.Key)
+		}
+		if len(page) < options.Limit {
+			break
+		}
+		options.After = page[len(page)-1].Key
+	}
+	if len(keys) != 13 {
+		t.Errorf("keys: %v", keys)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	kvs := scanSetup(t)
+	defer kvs.Close()
+
+	it := kvs.Iterate(&ScanOptions{Prefix: "user/", Values: true})
+	count := 0
+	for it.Next() {
+		kv := it.KeyValue()
+		if string(kv.Value) != "v-"+kv.Key {
+			t.Errorf("key: %s, value: %s", kv.Key, kv.Value)
+		}
+		count++
+	}
+	if err := it.Err(); err != nil || count != 4 {
+		t.Errorf("Iterate, count: %d, error: %v", count, err)
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("Close, error: %v", err)
+	}
+
+	// Stop early; the iterator is closed by All.
+	keys := []string{}
+	kvs.All(nil)(func(kv KeyValue, err error) bool {
+		if err != nil {
+			t.Errorf("All, error: %v", err)
+		}
+		keys = append(keys, kv.Key)
+		return len(keys) < 2
+	})
+	if !reflect.DeepEqual(keys, []string{"0012", "1"}) {
+		t.Errorf("All, keys: %v", keys)
+	}
+
+	// Errors are returned by the iterator.
+	kvs.DeleteStore()
+	errCount := 0
+	kvs.All(nil)(func(kv KeyValue, err error) bool {
+		if err != nil {
+			errCount++
+		}
+		return true
+	})
+	if errCount != 1 {
+		t.Errorf("All on deleted store, errCount: %d", errCount)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{"user/": "user0", "a": "b", "a\xff": "b", "\xff\xff": ""} {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefix: %q, got: %q, want: %q", prefix, got, want)
+		}
+	}
+}
+
+// scanSetup creates a KVS with keys for scan tests, and an expired key that is not returned.
+func scanSetup(t *testing.T) KVS {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	values := map[string][]byte{}
+	// Keys that look like numbers are compared and ordered as text.
+	for _, key := range []string{"user/3/name", "user/1/name", "a", "users", "user/2/name", "user/1/settings",
+		"1", "10", "100", "2", "0012", "1a", "1e3"} {
+		values[key] = []byte(fmt.Sprintf("v-%s", key))
+	}
+	if err := kvs.SetMany(values); err != nil {
+		t.Fatalf("SetMany, error: %v", err)
+	}
+	if err := kvs.SetWithTTL("user/0/expired", []byte("v"), -time.Second); err != nil {
+		t.Fatalf("SetWithTTL, error: %v", err)
+	}
+	return kvs
+}
@@ -3,8 +3,6 @@
 package kvs
 
 import (
-	"encoding/json"
-
 	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
 )
 
@@ -13,10 +11,26 @@ import (
 // If fields in the persisted object are non-nil, they will overwrite fields in the
 // provided object, otherwise values in the provide object will be in the returned object.
 // If the key is not in the KVS, obj is unchanged and there is no error.
+// The Codec is selected by the header of the persisted data; see Codec.
 func (kvs KVS) Deserialize(key string, obj interface{}) error {
+	return deserialize(kvs.Get, key, obj)
+}
+
+// Serialize serializes an object into the KVS, with the Codec from Options; JSON by default.
+func (kvs KVS) Serialize(key string, obj interface{}) error {
+	return serialize(kvs.Set, kvs.codec, key, obj)
+}
+
+// SerializeWithCodec is Serialize using codec, rather than the Codec of the KVS.
+func (kvs KVS) SerializeWithCodec(key string, obj interface{}, codec Codec) error {
+	return serialize(kvs.Set, codec, key, obj)
+}
+
+// deserialize implements Deserialize for any Store, using get.
+func deserialize(get func(string) ([]byte, error), key string, obj interface{}) error {
 	var b []byte
 	var err error
-	if b, err = kvs.Get(key); err != nil {
+	if b, err = get(key); err != nil {
 		return runtimeh.SourceInfoError("", err)
 	}
 
@@ -25,21 +39,43 @@ func (kvs KVS) Deserialize(key string, obj interface{}) error {
 		return nil
 	}
 
+	return decode(b, obj)
+}
+
+// decode merges serialized data into obj, using the Codec from the header of b.
+func decode(b []byte, obj interface{}) error {
+	codec, err := codecFor(b)
+	if err != nil {
+		return err
+	}
+	if codec.ID() != 0 {
+		b = b[1:]
+	}
 	// Merge persisted data into obj.
-	if err := json.Unmarshal(b, &obj); err != nil {
+	if err := codec.Unmarshal(b, obj); err != nil {
 		return runtimeh.SourceInfoError("", err)
 	}
 
 	return nil
 }
 
-// Serialize serializes an object into the KVS.
-func (kvs KVS) Serialize(key string, obj interface{}) error {
-	var b []byte
-	var err error
-	if b, err = json.Marshal(obj); err != nil {
+// serialize implements Serialize for any Store, using set and codec; a nil codec is JSON.
+func serialize(set func(string, []byte) error, codec Codec, key string, obj interface{}) error {
+	if codec == nil {
+		codec = JSON
+	}
+	id := codec.ID()
+	// The codec must be registered, or the data could not be deserialized.
+	if _, err := codecFor([]byte{id}); id != 0 && err != nil {
+		return err
+	}
+	b, err := codec.Marshal(obj)
+	if err != nil {
 		return runtimeh.SourceInfoError("", err)
 	}
+	if id != 0 {
+		b = append([]byte{id}, b...)
+	}
 
-	return runtimeh.SourceInfoError("", kvs.Set(key, b))
+	return runtimeh.SourceInfoError("", set(key, b))
 }
@@ -4,6 +4,8 @@ package kvs
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/paulfdunn/go-helper/databaseh/v2"
 	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
@@ -11,29 +13,89 @@ import (
 
 // KVS is an instance for key/value storage.
 type KVS struct {
-	dbConn *sql.DB
-	table  string
+	cache   *cache
+	codec   Codec
+	dbConn  *sql.DB
+	keyring *Keyring
+	// name is the table name, and table is name quoted for use in queries.
+	name string
+	// readConn is used for reads outside of transactions; it is dbConn unless
+	// Options.ReadConnections is set.
+	readConn *sql.DB
+	table    string
+}
+
+// Options are the options for NewWithOptions; the zero value is the same as New.
+type Options struct {
+	// Cache, when not nil, caches values read by Get in this process; see CacheOptions.
+	Cache *CacheOptions
+	// Codec is used by Serialize; nil is JSON.
+	Codec Codec
+	// Keyring, when not nil, encrypts values with AES-GCM; see Keyring.
+	Keyring *Keyring
+	// ReadConnections, when greater than zero, opens separate pools for reads and writes:
+	// a read pool of ReadConnections query only connections, and a write pool of one
+	// connection with immediate transactions, so writers in this process wait for each other
+	// rather than failing with "database is locked". Use with JournalMode WAL, so reads are
+	// not blocked by writes; do not use with an in-memory database, where each connection
+	// is a separate database. See BenchmarkConcurrent.
+	ReadConnections int
+	// SQLite are the SQLite settings; nil uses the defaults.
+	SQLite *databaseh.Options
 }
 
 // New creates a new key/value store, with a new or existing table, in the database for key/value storage.
 // The database file is created if it does not exist; an existing file is used if present.
-// The returned object has a single connection. If performance is an issue, create a pool of connections.
-// The GO sql package insures single threaded access to the connection, and thus it is thread safe.
+// Existing tables, created by earlier versions of this package, are upgraded with databaseh.Migrate.
+// The table name is quoted in queries, so any name can be used, except databaseh.MigrationsTable,
+// kvs_revisions, and the change log of an existing store, "<store>_changes"; Namespaces.Create is
+// stricter, so use it to create stores from user input.
+// The GO sql package manages a pool of connections, and the KVS is thread safe. With concurrent writers,
+// use NewWithOptions to set WAL, a busy timeout, and separate read and write pools.
 func New(dbConnectionString string, table string) (KVS, error) {
-	var dbConn *sql.DB
-	var err error
-	if dbConn, err = databaseh.Open(dbConnectionString); err != nil {
+	return NewWithOptions(dbConnectionString, table, nil)
+}
+
+// NewWithOptions is New with options; a nil options is the same as New.
+func NewWithOptions(dbConnectionString string, table string, options *Options) (KVS, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	var c *cache
+	if options.Cache != nil {
+		var err error
+		if c, err = newCache(*options.Cache); err != nil {
+			return KVS{}, err
+		}
+	}
+	dbConn, readConn, err := openPools(dbConnectionString, options)
+	if err != nil {
 		return KVS{}, runtimeh.SourceInfoError("opening db", err)
 	}
+	kvs := KVS{cache: c, codec: options.Codec, dbConn: dbConn, keyring: options.Keyring, name: table, readConn: readConn,
+		table: databaseh.QuoteIdentifier(table)}
 
-	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key string NOT NULL PRIMARY KEY, value BLOB);`, table)
-	_, err = sqlExec(dbConn, query)
-	return KVS{dbConn, table}, runtimeh.SourceInfoError("creating kvs table", err)
+	if err := checkTable(dbConn, table); err != nil {
+		if cerr := kvs.Close(); cerr != nil {
+			fmt.Printf("kvs.Close() error:%+v\n", cerr)
+		}
+		return KVS{}, err
+	}
+	if _, err := databaseh.Migrate(dbConn, schemaName(table), migrations(table)); err != nil {
+		return kvs, runtimeh.SourceInfoError("migrating kvs table", err)
+	}
+	return kvs, nil
 }
 
-// Close closes the database connection.
+// Close closes the database connections.
 func (kvs KVS) Close() error {
-	return kvs.dbConn.Close()
+	err := kvs.dbConn.Close()
+	if kvs.readConn != nil && kvs.readConn != kvs.dbConn {
+		if rerr := kvs.readConn.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
 }
 
 // Delete deletes a key from the KVS; returns the count, which is zero (and no error) if the key did not exist.
@@ -41,8 +103,14 @@ func (kvs KVS) Delete(key string) (int64, error) {
 	if kvs.dbConn == nil {
 		return 0, fmt.Errorf("%s kvs dbConn is nil", runtimeh.SourceInfo())
 	}
+	count, err := kvs.delete(kvs.dbConn, key)
+	kvs.cache.remove(key)
+	return count, err
+}
 
-	stmt, err := kvs.dbConn.Prepare(fmt.Sprintf(`DELETE FROM %s WHERE key=?;`, kvs.table))
+// delete deletes a key using q, which is the database or a transaction.
+func (kvs KVS) delete(q querier, key string) (int64, error) {
+	stmt, err := q.Prepare(fmt.Sprintf(`DELETE FROM %s WHERE key=?;`, kvs.table))
 	if err != nil {
 		return 0, runtimeh.SourceInfoError("", err)
 	}
@@ -64,25 +132,34 @@ func (kvs KVS) Delete(key string) (int64, error) {
 	return count, nil
 }
 
-// DeleteStore drops the table associated with the KVS.
+// DeleteStore drops the table associated with the KVS, and the change log table, and deletes
+// the recorded schema version.
 func (kvs KVS) DeleteStore() error {
 	if kvs.dbConn == nil {
 		return fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
 	}
 
-	query := fmt.Sprintf(`DROP TABLE IF EXISTS %s ;`, kvs.table)
-	_, err := sqlExec(kvs.dbConn, query)
-	return runtimeh.SourceInfoError("", err)
+	return kvs.Update(func(tx *Tx) error {
+		tx.invalidateAll()
+		return dropStore(tx.tx, kvs.name)
+	})
 }
 
 // Get gets a value from the KVS.
-// If the return data and error are both nil, the key did not exist.
+// If the return data and error are both nil, the key did not exist or has expired.
 func (kvs KVS) Get(key string) ([]byte, error) {
 	if kvs.dbConn == nil {
 		return nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
 	}
+	if kvs.cache != nil {
+		return kvs.cachedGet(key)
+	}
+	return kvs.get(kvs.readConn, key)
+}
 
-	stmt, err := kvs.dbConn.Prepare(fmt.Sprintf(`SELECT value FROM %s WHERE key=?;`, kvs.table))
+// get gets a value using q, which is the database or a transaction.
+func (kvs KVS) get(q querier, key string) ([]byte, error) {
+	stmt, err := q.Prepare(fmt.Sprintf(`SELECT value FROM %s WHERE key=? AND %s;`, kvs.table, notExpired))
 	if err != nil {
 		return nil, runtimeh.SourceInfoError("", err)
 	}
@@ -92,7 +169,7 @@ func (kvs KVS) Get(key string) ([]byte, error) {
 		}
 	}()
 
-	rows, err := stmt.Query(key)
+	rows, err := stmt.Query(key, time.Now().UnixNano())
 	if err != nil {
 		return nil, runtimeh.SourceInfoError("", err)
 	}
@@ -118,16 +195,16 @@ func (kvs KVS) Get(key string) ([]byte, error) {
 		return nil, runtimeh.SourceInfoError("scan iteration error", err)
 	}
 
-	return value, nil
+	return kvs.keyring.open(key, value)
 }
 
-// Keys returns all keys in the store.
+// Keys returns all keys in the store, except expired keys.
 func (kvs KVS) Keys() ([]string, error) {
 	if kvs.dbConn == nil {
 		return nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
 	}
 
-	rows, err := sqlQuery(kvs.dbConn, fmt.Sprintf("SELECT key FROM %s;", kvs.table))
+	rows, err := kvs.readConn.Query(fmt.Sprintf("SELECT key FROM %s WHERE %s;", kvs.table, notExpired), time.Now().UnixNano())
 	if err != nil {
 		return nil, runtimeh.SourceInfoError("getting all keys", err)
 	}
@@ -142,13 +219,21 @@ func (kvs KVS) Keys() ([]string, error) {
 	return keys, nil
 }
 
-// Set sets a value for the specified key in the KVS.
+// Set sets a value for the specified key in the KVS. The key does not expire; any
+// time-to-live from SetWithTTL, and any content type from SetWithContentType, is removed.
 func (kvs KVS) Set(key string, value []byte) error {
 	if kvs.dbConn == nil {
 		return fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
 	}
+	err := kvs.set(kvs.dbConn, key, value, nil, "")
+	kvs.cache.remove(key)
+	return err
+}
 
-	stmt, err := kvs.dbConn.Prepare(fmt.Sprintf(`REPLACE INTO %s Values (?,?);`, kvs.table))
+// set sets a value, the expiry time in Unix nanoseconds, and the content type, using q, which
+// is the database or a transaction; a nil expiresAt does not expire.
+func (kvs KVS) set(q querier, key string, value []byte, expiresAt *int64, contentType string) error {
+	stmt, err := q.Prepare(fmt.Sprintf(upsert, kvs.table))
 	if err != nil {
 		return runtimeh.SourceInfoError("", err)
 	}
@@ -157,7 +242,10 @@ func (kvs KVS) Set(key string, value []byte) error {
 			fmt.Printf("stmt.Close() error:%+v\n", err)
 		}
 	}()
-	_, err = stmt.Exec(key, value)
+	if value, err = kvs.keyring.seal(key, value); err != nil {
+		return err
+	}
+	_, err = stmt.Exec(key, value, expiresAt, nullString(contentType), time.Now().UnixNano(), kvs.name)
 	if err != nil {
 		return runtimeh.SourceInfoError("", err)
 	}
@@ -165,6 +253,189 @@ func (kvs KVS) Set(key string, value []byte) error {
 	return nil
 }
 
+// addColumn adds a column to the table, if it does not exist.
+func addColumn(q querier, table string, name string, definition string) error {
+	var count int
+	row := q.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?;`, table, name)
+	if err := row.Scan(&count); err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := q.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, databaseh.QuoteIdentifier(table), name, definition))
+	return runtimeh.SourceInfoError("", err)
+}
+
+// rebuildTextKey rebuilds the table, and its change log, with a key column of TEXT affinity.
+// The key column was declared "string", which has NUMERIC affinity, so keys that look like
+// numbers were compared, ordered, and stored as numbers; I.E. "0012" was stored as 12. Keys
+// already stored as numbers are converted to text; the original text can not be recovered.
+func rebuildTextKey(tx *sql.Tx, table string) error {
+	rows, err := tx.Query(`SELECT name, type, "notnull", dflt_value, pk FROM pragma_table_info(?) ORDER BY cid;`, table)
+	if err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	definitions := []string{}
+	textKey := false
+	for rows.Next() {
+		var name, columnType string
+		var notNull, pk bool
+		var dflt sql.NullString
+		if err := rows.Scan(&name, &columnType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return runtimeh.SourceInfoError("scan error", err)
+		}
+		if name == "key" {
+			textKey = strings.EqualFold(columnType, "TEXT")
+			definitions = append(definitions, "key TEXT NOT NULL PRIMARY KEY")
+			continue
+		}
+		definition := databaseh.QuoteIdentifier(name) + " " + columnType
+		if notNull {
+			definition += " NOT NULL"
+		}
+		if dflt.Valid {
+			definition += " DEFAULT " + dflt.String
+		}
+		definitions = append(definitions, definition)
+	}
+	err = rows.Err()
+	if cerr := rows.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || textKey {
+		return runtimeh.SourceInfoError("scan iteration error", err)
+	}
+
+	_, changeLog, err := findChangeLog(tx, table)
+	if err != nil {
+		return err
+	}
+	if err := rebuildTable(tx, table, fmt.Sprintf(`CREATE TABLE %s (%s);`,
+		databaseh.QuoteIdentifier(table), strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+	if !changeLog {
+		return nil
+	}
+	// Dropping the table dropped the change log triggers, which are recreated with the index.
+	changes := changeTableName(table)
+	var seq sql.NullInt64
+	if err := tx.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name=?;`, changes).Scan(&seq); err != nil && err != sql.ErrNoRows {
+		return runtimeh.SourceInfoError("", err)
+	}
+	if err := rebuildTable(tx, changes, fmt.Sprintf(createChangeTable, databaseh.QuoteIdentifier(changes))); err != nil {
+		return err
+	}
+	if seq.Valid {
+		// Keep the sequence, so Watch does not miss changes after the change log is emptied.
+		if _, err := tx.Exec(`UPDATE sqlite_sequence SET seq=MAX(seq, ?) WHERE name=?;`, seq.Int64, changes); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+		if _, err := tx.Exec(`INSERT INTO sqlite_sequence (name, seq) SELECT ?, ?
+			WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name=?);`, changes, seq.Int64, changes); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+	}
+	return createChangeLog(tx, table)
+}
+
+// rebuildTable replaces the table with a table created by the query create, and copies all
+// rows, using a temporary table.
+func rebuildTable(tx *sql.Tx, table string, create string) error {
+	columns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	main := "main." + databaseh.QuoteIdentifier(table)
+	queries := []string{
+		`DROP TABLE IF EXISTS temp.kvs_rebuild;`,
+		fmt.Sprintf(`CREATE TEMP TABLE kvs_rebuild AS SELECT * FROM %s;`, main),
+		fmt.Sprintf(`DROP TABLE %s;`, main),
+		create,
+		fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM temp.kvs_rebuild;`, main, columns, columns),
+		`DROP TABLE temp.kvs_rebuild;`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return runtimeh.SourceInfoError("", err)
+		}
+	}
+	return nil
+}
+
+// migrations returns the migrations of the table. Tables created before migrations were
+// recorded may already have the columns of later migrations, so columns are only added if
+// they do not exist. Add new migrations to the end; never change existing migrations.
+func migrations(table string) []databaseh.Migration {
+	return []databaseh.Migration{
+		{Version: 1, Description: "create table", Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (key string NOT NULL PRIMARY KEY, value BLOB);`,
+				databaseh.QuoteIdentifier(table)))
+			return err
+		}},
+		{Version: 2, Description: "add expires_at", Up: func(tx *sql.Tx) error {
+			return addColumn(tx, table, "expires_at", "INTEGER")
+		}},
+		{Version: 3, Description: "add version", Up: func(tx *sql.Tx) error {
+			return addColumn(tx, table, "version", "INTEGER NOT NULL DEFAULT 1")
+		}},
+		{Version: 4, Description: "add metadata", Up: func(tx *sql.Tx) error {
+			// Existing keys have NULL created_at and updated_at; see Metadata.
+			for _, column := range [][2]string{{"content_type", "TEXT"}, {"created_at", "INTEGER"}, {"updated_at", "INTEGER"}} {
+				if err := addColumn(tx, table, column[0], column[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{Version: 5, Description: "rebuild with a TEXT key", Up: func(tx *sql.Tx) error {
+			return rebuildTextKey(tx, table)
+		}},
+		{Version: 6, Description: "record deleted versions", Up: func(tx *sql.Tx) error {
+			return createRevisions(tx, table)
+		}},
+	}
+}
+
+// openPools opens the write pool, and the read pool; the read pool is the write pool unless
+// options.ReadConnections is greater than zero.
+func openPools(dbConnectionString string, options *Options) (*sql.DB, *sql.DB, error) {
+	if options.ReadConnections <= 0 {
+		dbConn, err := databaseh.OpenWithOptions(dbConnectionString, options.SQLite)
+		return dbConn, dbConn, err
+	}
+
+	sqliteOptions := databaseh.Options{}
+	if options.SQLite != nil {
+		sqliteOptions = *options.SQLite
+	}
+	writeOptions := sqliteOptions
+	writeOptions.MaxOpenConns = 1
+	writeOptions.TxLock = "immediate"
+	dbConn, err := databaseh.OpenWithOptions(dbConnectionString, &writeOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	readOptions := sqliteOptions
+	readOptions.MaxOpenConns = options.ReadConnections
+	readOptions.QueryOnly = true
+	readConn, err := databaseh.OpenWithOptions(dbConnectionString, &readOptions)
+	if err != nil {
+		if cerr := dbConn.Close(); cerr != nil {
+			fmt.Printf("dbConn.Close() error:%+v\n", cerr)
+		}
+		return nil, nil, err
+	}
+	return dbConn, readConn, nil
+}
+
+// schemaName is the name of the schema of the table, for databaseh.Migrate.
+func schemaName(table string) string {
+	return "kvs:" + table
+}
+
 func sqlExec(db *sql.DB, query string) (sql.Result, error) {
 	result, err := db.Exec(query)
 	// if err != nil {
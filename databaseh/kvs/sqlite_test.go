@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/paulfdunn/go-helper/databaseh/v2"
 	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
 )
 
@@ -213,6 +216,193 @@ func TestGetNegative(t *testing.T) {
 	}
 }
 
+// TestRebuildTextKey shows that a table, and change log, with a key column of NUMERIC
+// affinity, from before migration 5, are rebuilt with a TEXT key.
+func TestRebuildTextKey(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	_, stop, err := kvs.Watch("", time.Millisecond)
+	if err != nil {
+		t.Fatalf("Watch, error: %v", err)
+	}
+	stop()
+	// Recreate the tables as they were at version 4.
+	err = kvs.Update(func(tx *Tx) error {
+		if err := rebuildTable(tx.tx, table, `CREATE TABLE testTable (key string NOT NULL PRIMARY KEY, value BLOB,
+			expires_at INTEGER, version INTEGER NOT NULL DEFAULT 1, content_type TEXT, created_at INTEGER, updated_at INTEGER);`); err != nil {
+			return err
+		}
+		if err := rebuildTable(tx.tx, changeTableName(table), `CREATE TABLE testTable_changes (seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			key string NOT NULL, op INTEGER NOT NULL, value BLOB, version INTEGER, changed_at INTEGER NOT NULL);`); err != nil {
+			return err
+		}
+		if err := createChangeLog(tx.tx, table); err != nil {
+			return err
+		}
+		_, err := tx.tx.Exec(fmt.Sprintf(`UPDATE %s SET version=4 WHERE schema=?;`, databaseh.MigrationsTable), schemaName(table))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Update, error: %v", err)
+	}
+	if err := kvs.SetMany(map[string][]byte{"9": []byte("v9"), "10": []byte("v10"), "k1": []byte("v1")}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+	if _, err := kvs.DeleteChanges(-time.Hour); err != nil {
+		t.Errorf("DeleteChanges, error: %v", err)
+	}
+	var seq int64
+	if err := kvs.dbConn.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name=?;`, changeTableName(table)).Scan(&seq); err != nil {
+		t.Errorf("sqlite_sequence, error: %v", err)
+	}
+	kvs.Close()
+
+	kvs, err = New(dataSourceName, table)
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+	if version, err := databaseh.SchemaVersion(kvs.dbConn, schemaName(table)); version != len(migrations(table)) || err != nil {
+		t.Errorf("SchemaVersion, version: %d, error: %v", version, err)
+	}
+	if page, err := kvs.Scan(&ScanOptions{Values: true}); err != nil || len(page) != 3 ||
+		page[0].Key != "10" || page[1].Key != "9" || string(page[1].Value) != "v9" {
+		t.Errorf("Scan, page: %+v, error: %v", page, err)
+	}
+
+	// The change log triggers are recreated, and the sequence continues.
+	if err := kvs.Set("0012", []byte("v")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	var key string
+	var newSeq int64
+	if err := kvs.dbConn.QueryRow(`SELECT seq, key FROM testTable_changes;`).Scan(&newSeq, &key); err != nil ||
+		newSeq != seq+1 || key != "0012" {
+		t.Errorf("change log, seq: %d, key: %s, error: %v", newSeq, key, err)
+	}
+}
+
+// TestNewWithOptions shows that concurrent writers, in this process and in others, do not
+// fail with "database is locked".
+func TestNewWithOptions(t *testing.T) {
+	// WAL adds files next to the database, so it has its own directory.
+	dataSourceName := filepath.Join(t.TempDir(), "wal.db")
+	options := &Options{
+		ReadConnections: 4,
+		SQLite:          &databaseh.Options{BusyTimeout: 10 * time.Second, JournalMode: "WAL", Synchronous: "NORMAL"},
+	}
+	stores := []KVS{}
+	for i := 0; i < 3; i++ {
+		// Each KVS is as if from another process.
+		kvs, err := NewWithOptions(dataSourceName, "testTable", options)
+		if err != nil {
+			t.Fatalf("NewWithOptions, error: %v", err)
+		}
+		defer kvs.Close()
+		stores = append(stores, kvs)
+	}
+	if _, err := stores[0].readConn.Exec(`DELETE FROM testTable;`); err == nil {
+		t.Errorf("write on read pool did not return an error")
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			kvs := stores[i%len(stores)]
+			key := fmt.Sprintf("k%d", i)
+			for j := 0; j < 10; j++ {
+				if err := kvs.Set(key, []byte(key)); err != nil {
+					t.Errorf("Set, error: %v", err)
+				}
+				if err := kvs.SetMany(map[string][]byte{key + "/many": []byte(key)}); err != nil {
+					t.Errorf("SetMany, error: %v", err)
+				}
+				if b, err := kvs.Get(key); string(b) != key || err != nil {
+					t.Errorf("Get, value: %s, error: %v", b, err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if keys, err := stores[0].Keys(); len(keys) != 60 || err != nil {
+		t.Errorf("Keys, count: %d, error: %v", len(keys), err)
+	}
+
+	// GetMany uses the read pool, so it does not wait for the write transaction, which holds
+	// the only connection of the write pool.
+	err := stores[0].Update(func(tx *Tx) error {
+		if err := tx.Set("k1", []byte("changed")); err != nil {
+			return err
+		}
+		values, err := stores[0].GetMany([]string{"k1", "k2"})
+		if string(values["k1"]) != "k1" || string(values["k2"]) != "k2" {
+			t.Errorf("GetMany during Update, values: %v", values)
+		}
+		return err
+	})
+	if err != nil {
+		t.Errorf("Update, error: %v", err)
+	}
+}
+
+// BenchmarkConcurrent compares concurrent Set and Get, 1 Set for every 9 Get, with the
+// default options, WAL, and WAL with separate read and write pools.
+func BenchmarkConcurrent(b *testing.B) {
+	wal := &databaseh.Options{BusyTimeout: 10 * time.Second, JournalMode: "WAL", Synchronous: "NORMAL"}
+	benchmarks := []struct {
+		name    string
+		options *Options
+	}{
+		{"Default", &Options{SQLite: &databaseh.Options{BusyTimeout: 10 * time.Second}}},
+		{"WAL", &Options{SQLite: wal}},
+		{"WALPools", &Options{ReadConnections: 8, SQLite: wal}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			kvs, err := NewWithOptions(filepath.Join(b.TempDir(), "benchmark.db"), "benchmarkTable", bm.options)
+			if err != nil {
+				b.Fatalf("NewWithOptions, error: %v", err)
+			}
+			defer kvs.Close()
+			for k := 0; k < benchmarkKeys; k++ {
+				if err := kvs.Set(fmt.Sprintf("k%d", k), []byte("value")); err != nil {
+					b.Fatalf("Set, error: %v", err)
+				}
+			}
+			b.SetParallelism(4)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					var err error
+					key := fmt.Sprintf("k%d", i%benchmarkKeys)
+					if i%10 == 0 {
+						err = kvs.Set(key, []byte("value"))
+					} else {
+						_, err = kvs.Get(key)
+					}
+					if err != nil {
+						b.Errorf("Set or Get, error: %v", err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
 func (kvps kvPairs) add(t *testing.T, kvs KVS) (map[string]string, error) {
 	kvMap := make(map[string]string)
 	for _, v := range kvps {
@@ -0,0 +1,112 @@
+package kvs
+
+import (
+	"sync"
+)
+
+// Store is the interface for key/value storage that is implemented by KVS (SQLITE3),
+// MemoryStore, and FileStore; I.E. services can use a MemoryStore in unit tests. The
+// behavior of each method is documented on KVS, except that after DeleteStore a
+// MemoryStore, or FileStore, is empty, while a KVS returns errors as its table is dropped.
+type Store interface {
+	Close() error
+	Delete(key string) (int64, error)
+	DeleteStore() error
+	Deserialize(key string, obj interface{}) error
+	Get(key string) ([]byte, error)
+	Keys() ([]string, error)
+	Serialize(key string, obj interface{}) error
+	Set(key string, value []byte) error
+}
+
+// MemoryStore is a Store that keeps all keys and values in memory, in a map. It is safe for
+// concurrent use.
+type MemoryStore struct {
+	mutex  sync.RWMutex
+	values map[string][]byte
+}
+
+var (
+	_ Store = KVS{}
+	_ Store = (*FileStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// NewMemory creates an empty MemoryStore.
+func NewMemory() *MemoryStore {
+	return &MemoryStore{values: make(map[string][]byte)}
+}
+
+// Close does nothing; a MemoryStore does not hold any resources.
+func (ms *MemoryStore) Close() error {
+	return nil
+}
+
+// Delete deletes a key; returns the count, which is zero if the key did not exist.
+func (ms *MemoryStore) Delete(key string) (int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if _, ok := ms.values[key]; !ok {
+		return 0, nil
+	}
+	delete(ms.values, key)
+	return 1, nil
+}
+
+// DeleteStore deletes all keys.
+func (ms *MemoryStore) DeleteStore() error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.values = make(map[string][]byte)
+	return nil
+}
+
+// Deserialize is KVS.Deserialize.
+func (ms *MemoryStore) Deserialize(key string, obj interface{}) error {
+	return deserialize(ms.Get, key, obj)
+}
+
+// Get gets a copy of the value of key; the value and error are both nil if the key does
+// not exist.
+func (ms *MemoryStore) Get(key string) ([]byte, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	value, ok := ms.values[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyValue(value), nil
+}
+
+// Keys returns all keys, in no particular order.
+func (ms *MemoryStore) Keys() ([]string, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	keys := make([]string, 0, len(ms.values))
+	for key := range ms.values {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Serialize is KVS.Serialize.
+func (ms *MemoryStore) Serialize(key string, obj interface{}) error {
+	return serialize(ms.Set, nil, key, obj)
+}
+
+// Set sets a copy of value for key.
+func (ms *MemoryStore) Set(key string, value []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.values[key] = copyValue(value)
+	return nil
+}
+
+// copyValue returns a copy of value; a nil value, which KVS stores as NULL and Get returns
+// as nil, stays nil.
+func copyValue(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
@@ -0,0 +1,214 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// conformancePairs are the keys and values used by testStoreConformance.
+var conformancePairs = kvPairs{
+	{key: "k1", value: []byte("key1")},
+	{key: "k2", value: []byte("key2")},
+	{key: "user/1", value: []byte("user1")},
+	// Keys that look like numbers are distinct keys, and are returned unchanged.
+	{key: "0012", value: []byte("0012")},
+	{key: "12", value: []byte("12")},
+	{key: "1e3", value: []byte("1e3")},
+}
+
+// TestStoreConformance runs the same tests against every Store implementation.
+func TestStoreConformance(t *testing.T) {
+	backends := map[string]func(t *testing.T) Store{
+		"KVS": func(t *testing.T) Store {
+			if err := testSetup(); err != nil {
+				if _, ok := err.(*os.PathError); !ok {
+					t.Errorf("testSetup error; %+v", err)
+				}
+			}
+			kvs, err := New(dataSourceName, "testTable")
+			if err != nil {
+				t.Fatalf("New, error: %v", err)
+			}
+			return kvs
+		},
+		"MemoryStore": func(t *testing.T) Store {
+			return NewMemory()
+		},
+		"FileStore": func(t *testing.T) Store {
+			fs, err := NewFile(filepath.Join(t.TempDir(), "test.kvs"))
+			if err != nil {
+				t.Fatalf("NewFile, error: %v", err)
+			}
+			return fs
+		},
+	}
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			testStoreConformance(t, newStore)
+		})
+	}
+}
+
+func testStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("GetSetDelete", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		if b, err := store.Get("k1"); b != nil || err != nil {
+			t.Errorf("Get missing key, value: %s, error: %v", b, err)
+		}
+		if count, err := store.Delete("k1"); count != 0 || err != nil {
+			t.Errorf("Delete missing key, count: %d, error: %v", count, err)
+		}
+		for _, kvp := range conformancePairs {
+			if err := store.Set(kvp.key, kvp.value); err != nil {
+				t.Errorf("Set, error: %v", err)
+			}
+		}
+		for _, kvp := range conformancePairs {
+			if b, err := store.Get(kvp.key); string(b) != string(kvp.value) || err != nil {
+				t.Errorf("Get, key: %s, value: %s, error: %v", kvp.key, b, err)
+			}
+		}
+		if err := store.Set("k1", []byte("changed")); err != nil {
+			t.Errorf("Set, error: %v", err)
+		}
+		if b, err := store.Get("k1"); string(b) != "changed" || err != nil {
+			t.Errorf("Get changed key, value: %s, error: %v", b, err)
+		}
+		if count, err := store.Delete("k1"); count != 1 || err != nil {
+			t.Errorf("Delete, count: %d, error: %v", count, err)
+		}
+		if b, err := store.Get("k1"); b != nil || err != nil {
+			t.Errorf("Get deleted key, value: %s, error: %v", b, err)
+		}
+	})
+
+	t.Run("ValuesAreCopied", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		value := []byte("value")
+		if err := store.Set("k1", value); err != nil {
+			t.Errorf("Set, error: %v", err)
+		}
+		value[0] = 'X'
+		b, err := store.Get("k1")
+		if string(b) != "value" || err != nil {
+			t.Errorf("Get, value: %s, error: %v", b, err)
+		}
+		b[0] = 'X'
+		if b, err := store.Get("k1"); string(b) != "value" || err != nil {
+			t.Errorf("Get, value: %s, error: %v", b, err)
+		}
+	})
+
+	t.Run("NilValue", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		// A nil value is returned as nil, the same as a missing key, but the key exists; an
+		// empty value is not nil.
+		if err := store.Set("nil", nil); err != nil {
+			t.Errorf("Set, error: %v", err)
+		}
+		if err := store.Set("empty", []byte{}); err != nil {
+			t.Errorf("Set, error: %v", err)
+		}
+		if b, err := store.Get("nil"); b != nil || err != nil {
+			t.Errorf("Get nil value, value: %v, error: %v", b, err)
+		}
+		if b, err := store.Get("empty"); b == nil || len(b) != 0 || err != nil {
+			t.Errorf("Get empty value, value: %v, error: %v", b, err)
+		}
+		if keys, err := store.Keys(); len(keys) != 2 || err != nil {
+			t.Errorf("Keys, keys: %v, error: %v", keys, err)
+		}
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		want := []string{}
+		for _, kvp := range conformancePairs {
+			if err := store.Set(kvp.key, kvp.value); err != nil {
+				t.Errorf("Set, error: %v", err)
+			}
+			want = append(want, kvp.key)
+		}
+		keys, err := store.Keys()
+		sort.Strings(keys)
+		sort.Strings(want)
+		if err != nil || fmt.Sprint(keys) != fmt.Sprint(want) {
+			t.Errorf("Keys, keys: %v, want: %v, error: %v", keys, want, err)
+		}
+	})
+
+	t.Run("SerializeDeserialize", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		ts := TestSerialize{ID: 1, Name: "name"}
+		if err := store.Serialize("k1", ts); err != nil {
+			t.Errorf("Serialize, error: %v", err)
+		}
+		tsd := TestSerialize{}
+		if err := store.Deserialize("k1", &tsd); err != nil || tsd != ts {
+			t.Errorf("Deserialize, tsd: %+v, error: %v", tsd, err)
+		}
+		// A missing key leaves the object unchanged.
+		if err := store.Deserialize("missing", &tsd); err != nil || tsd != ts {
+			t.Errorf("Deserialize missing key, tsd: %+v, error: %v", tsd, err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("k%d", i)
+				if err := store.Set(key, []byte(key)); err != nil {
+					t.Errorf("Set, error: %v", err)
+				}
+				if b, err := store.Get(key); string(b) != key || err != nil {
+					t.Errorf("Get, value: %s, error: %v", b, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if keys, err := store.Keys(); len(keys) != 10 || err != nil {
+			t.Errorf("Keys, keys: %v, error: %v", keys, err)
+		}
+	})
+
+	t.Run("DeleteStore", func(t *testing.T) {
+		store := newStore(t)
+		defer store.Close()
+
+		if err := store.Set("k1", []byte("v1")); err != nil {
+			t.Errorf("Set, error: %v", err)
+		}
+		if err := store.DeleteStore(); err != nil {
+			t.Errorf("DeleteStore, error: %v", err)
+		}
+		// No keys, or values, are returned; a KVS also returns an error, as the table no longer
+		// exists, while the other stores are empty.
+		_, isKVS := store.(KVS)
+		if keys, err := store.Keys(); len(keys) != 0 || (err != nil) != isKVS {
+			t.Errorf("Keys after DeleteStore, keys: %v, error: %v", keys, err)
+		}
+		if b, err := store.Get("k1"); b != nil || (err != nil) != isKVS {
+			t.Errorf("Get after DeleteStore, value: %s, error: %v", b, err)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package kvs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// notExpired is a WHERE clause term that excludes expired rows; the parameter is the
+// current time in Unix nanoseconds.
+const notExpired = `(expires_at IS NULL OR expires_at > ?)`
+
+// DeleteExpired deletes expired keys, in batches of batchSize rows, so the database is
+// not locked for long periods; returns the count of deleted keys.
+func (kvs KVS) DeleteExpired(batchSize int) (int64, error) {
+	if kvs.dbConn == nil {
+		return 0, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("%s batchSize must be greater than zero", runtimeh.SourceInfo())
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE rowid IN
+		(SELECT rowid FROM %s WHERE expires_at IS NOT NULL AND expires_at <= ? LIMIT ?);`, kvs.table, kvs.table)
+	now := time.Now().UnixNano()
+	var total int64
+	for {
+		res, err := kvs.dbConn.Exec(query, now, batchSize)
+		if err != nil {
+			return total, runtimeh.SourceInfoError("", err)
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return total, runtimeh.SourceInfoError("", err)
+		}
+		total += count
+		if count < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+// SetWithTTL sets a value for the specified key in the KVS; after ttl the key is treated as
+// missing by Get and Keys, and is deleted by DeleteExpired.
+func (kvs KVS) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if kvs.dbConn == nil {
+		return fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	err := kvs.set(kvs.dbConn, key, value, expiresAt(ttl), "")
+	kvs.cache.remove(key)
+	return err
+}
+
+// StartSweeper starts a goroutine that calls DeleteExpired every interval. An error is
+// returned, and no goroutine started, if interval or batchSize is not greater than zero;
+// later errors are printed, as there is no caller to return them to. Call the returned
+// function to stop the sweeper; it returns after the goroutine exits, and must be called
+// before Close.
+func (kvs KVS) StartSweeper(interval time.Duration, batchSize int) (func(), error) {
+	if kvs.dbConn == nil {
+		return nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%s interval must be greater than zero", runtimeh.SourceInfo())
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("%s batchSize must be greater than zero", runtimeh.SourceInfo())
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := kvs.DeleteExpired(batchSize); err != nil {
+					fmt.Printf("kvs sweeper DeleteExpired error:%+v\n", err)
+				}
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}, nil
+}
+
+// expiresAt returns the expiry time, in Unix nanoseconds, for a time-to-live of ttl.
+func expiresAt(ttl time.Duration) *int64 {
+	expiresAt := time.Now().Add(ttl).UnixNano()
+	return &expiresAt
+}
@@ -0,0 +1,173 @@
+package kvs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/paulfdunn/go-helper/databaseh/v2"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	if err := kvs.SetWithTTL("k1", []byte("v1"), 100*time.Millisecond); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+		return
+	}
+	if err := kvs.SetWithTTL("k2", []byte("v2"), -time.Second); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+		return
+	}
+	if err := kvs.Set("k3", []byte("v3")); err != nil {
+		t.Errorf("Set, error: %v", err)
+		return
+	}
+	if b, err := kvs.Get("k1"); err != nil || string(b) != "v1" {
+		t.Errorf("Get before expiry, value: %s, error: %v", b, err)
+	}
+	if b, err := kvs.Get("k2"); err != nil || b != nil {
+		t.Errorf("Get expired key, value: %s, error: %v", b, err)
+	}
+	if keys, err := kvs.Keys(); err != nil || len(keys) != 2 {
+		t.Errorf("Keys, keys: %v, error: %v", keys, err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if b, err := kvs.Get("k1"); err != nil || b != nil {
+		t.Errorf("Get after expiry, value: %s, error: %v", b, err)
+	}
+	count, err := kvs.DeleteExpired(1)
+	if err != nil || count != 2 {
+		t.Errorf("DeleteExpired, count: %d, error: %v", count, err)
+	}
+	if count, err := rowCount(kvs.dbConn, table); err != nil || count != 1 {
+		t.Errorf("rowCount, count: %d, error: %v", count, err)
+	}
+
+	// Set removes the TTL.
+	if err := kvs.SetWithTTL("k3", []byte("v3"), -time.Second); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	if err := kvs.Set("k3", []byte("v3")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if b, err := kvs.Get("k3"); err != nil || string(b) != "v3" {
+		t.Errorf("Get after Set, value: %s, error: %v", b, err)
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		if err := kvs.SetWithTTL(key, []byte(key), 10*time.Millisecond); err != nil {
+			t.Errorf("SetWithTTL, error: %v", err)
+			return
+		}
+	}
+	for _, args := range []struct {
+		interval  time.Duration
+		batchSize int
+	}{{0, 2}, {-time.Millisecond, 2}, {20 * time.Millisecond, 0}} {
+		if _, err := kvs.StartSweeper(args.interval, args.batchSize); err == nil {
+			t.Errorf("StartSweeper with invalid arguments did not return an error, args: %+v", args)
+		}
+	}
+	stop, err := kvs.StartSweeper(20*time.Millisecond, 2)
+	if err != nil {
+		t.Errorf("StartSweeper, error: %v", err)
+		return
+	}
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	stop()
+	if count, err := rowCount(kvs.dbConn, table); err != nil || count != 0 {
+		t.Errorf("rowCount, count: %d, error: %v", count, err)
+	}
+}
+
+// TestMigrateColumns shows that a table created before columns were added is migrated by New.
+func TestMigrateColumns(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	db, err := databaseh.Open(dataSourceName)
+	if err != nil {
+		t.Errorf("Open, error: %v", err)
+		return
+	}
+	if _, err := sqlExec(db, `CREATE TABLE testTable (key string NOT NULL PRIMARY KEY, value BLOB);`); err != nil {
+		t.Errorf("CREATE TABLE, error: %v", err)
+		return
+	}
+	if _, err := sqlExec(db, `INSERT INTO testTable Values ('k1', 'v1');`); err != nil {
+		t.Errorf("INSERT, error: %v", err)
+		return
+	}
+	db.Close()
+
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+	if b, version, err := kvs.GetWithVersion("k1"); err != nil || string(b) != "v1" || version != 1 {
+		t.Errorf("GetWithVersion, value: %s, version: %d, error: %v", b, version, err)
+	}
+	if err := kvs.SetWithTTL("k2", []byte("v2"), time.Hour); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	// Keys written before metadata was recorded have no created or updated time.
+	if md, err := kvs.Stat("k1"); err != nil || md == nil || !md.CreatedAt.IsZero() || !md.UpdatedAt.IsZero() || md.Size != 2 {
+		t.Errorf("Stat, metadata: %+v, error: %v", md, err)
+	}
+
+	if version, err := databaseh.SchemaVersion(kvs.dbConn, schemaName(table)); version != len(migrations(table)) || err != nil {
+		t.Errorf("SchemaVersion, version: %d, error: %v", version, err)
+	}
+
+	// Opening a migrated table again is not an error.
+	kvs2, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	kvs2.Close()
+
+	if err := kvs.DeleteStore(); err != nil {
+		t.Errorf("DeleteStore, error: %v", err)
+	}
+	if version, err := databaseh.SchemaVersion(kvs.dbConn, schemaName(table)); version != 0 || err != nil {
+		t.Errorf("SchemaVersion after DeleteStore, version: %d, error: %v", version, err)
+	}
+}
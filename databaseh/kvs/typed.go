@@ -0,0 +1,114 @@
+package kvs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// TypedStore stores values of type T in a Store, with Serialize, so callers get and set T
+// rather than passing interface{} to Deserialize. Use a separate store, or key prefix, for
+// each type.
+type TypedStore[T any] struct {
+	options TypedOptions[T]
+	store   Store
+}
+
+// TypedOptions are the options for NewTyped; the zero value has no default and no
+// validation.
+type TypedOptions[T any] struct {
+	// Default, when not nil, returns the value for a missing key. As with Deserialize, the
+	// persisted value of an existing key is merged into the value from Default; I.E. fields
+	// added to T since the value was persisted have the default value.
+	Default func() T
+	// Validate, when not nil, is called by Set; the value is not set if Validate returns an
+	// error.
+	Validate func(T) error
+}
+
+// NewTyped creates a TypedStore using store; a nil options is the zero TypedOptions.
+func NewTyped[T any](store Store, options *TypedOptions[T]) *TypedStore[T] {
+	if options == nil {
+		options = &TypedOptions[T]{}
+	}
+	return &TypedStore[T]{options: *options, store: store}
+}
+
+// Delete is Store.Delete.
+func (ts *TypedStore[T]) Delete(key string) (int64, error) {
+	return ts.store.Delete(key)
+}
+
+// Get gets the value of key, merged into the value from Default, and true; if the key does
+// not exist it returns the value from Default, or the zero value, and false.
+func (ts *TypedStore[T]) Get(key string) (T, bool, error) {
+	value := ts.newValue()
+	b, err := ts.store.Get(key)
+	if err != nil {
+		return value, false, runtimeh.SourceInfoError("", err)
+	}
+	if b == nil {
+		return value, false, nil
+	}
+	if err := decode(b, &value); err != nil {
+		return value, false, err
+	}
+	return value, true, nil
+}
+
+// Keys returns the keys that start with prefix, in ascending order; an empty prefix returns
+// all keys.
+func (ts *TypedStore[T]) Keys(prefix string) ([]string, error) {
+	all, err := ts.store.Keys()
+	if err != nil {
+		return nil, runtimeh.SourceInfoError("", err)
+	}
+	keys := []string{}
+	for _, key := range all {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// List returns the values of the keys that start with prefix, by key; an empty prefix
+// returns all values. Keys deleted while List runs are not returned.
+func (ts *TypedStore[T]) List(prefix string) (map[string]T, error) {
+	keys, err := ts.Keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		value, ok, err := ts.Get(key)
+		if err != nil {
+			return nil, runtimeh.SourceInfoError("key: "+key, err)
+		}
+		if ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// Set validates value, with Validate, then sets it with Store.Serialize.
+func (ts *TypedStore[T]) Set(key string, value T) error {
+	if ts.options.Validate != nil {
+		if err := ts.options.Validate(value); err != nil {
+			return err
+		}
+	}
+	return ts.store.Serialize(key, value)
+}
+
+// newValue returns the value from Default, or the zero value.
+func (ts *TypedStore[T]) newValue() T {
+	if ts.options.Default != nil {
+		return ts.options.Default()
+	}
+	var value T
+	return value
+}
@@ -0,0 +1,102 @@
+package kvs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type testConfig struct {
+	Name    string
+	Retries int
+	Tags    []string
+}
+
+func TestTypedStore(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+
+	errNoName := errors.New("name is required")
+	ts := NewTyped(kvs, &TypedOptions[testConfig]{
+		Default: func() testConfig { return testConfig{Retries: 3} },
+		Validate: func(c testConfig) error {
+			if c.Name == "" {
+				return errNoName
+			}
+			return nil
+		},
+	})
+
+	if c, ok, err := ts.Get("config/a"); ok || err != nil || c.Retries != 3 {
+		t.Errorf("Get missing key, value: %+v, ok: %t, error: %v", c, ok, err)
+	}
+	if err := ts.Set("config/a", testConfig{}); !errors.Is(err, errNoName) {
+		t.Errorf("Set invalid value, error: %v", err)
+	}
+	if b, err := kvs.Get("config/a"); b != nil || err != nil {
+		t.Errorf("invalid value was set, value: %s, error: %v", b, err)
+	}
+
+	want := testConfig{Name: "a", Retries: 5, Tags: []string{"x"}}
+	if err := ts.Set("config/a", want); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if c, ok, err := ts.Get("config/a"); !ok || err != nil || !reflect.DeepEqual(c, want) {
+		t.Errorf("Get, value: %+v, ok: %t, error: %v", c, ok, err)
+	}
+
+	// A value persisted without a field gets the default for that field.
+	if err := kvs.Set("config/b", []byte(`{"Name":"b"}`)); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if c, ok, err := ts.Get("config/b"); !ok || err != nil || c.Name != "b" || c.Retries != 3 {
+		t.Errorf("Get merged, value: %+v, ok: %t, error: %v", c, ok, err)
+	}
+
+	if err := kvs.Set("other", []byte(`{}`)); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if keys, err := ts.Keys("config/"); err != nil || fmt.Sprint(keys) != "[config/a config/b]" {
+		t.Errorf("Keys, keys: %v, error: %v", keys, err)
+	}
+	values, err := ts.List("config/")
+	if err != nil || len(values) != 2 || !reflect.DeepEqual(values["config/a"], want) || values["config/b"].Retries != 3 {
+		t.Errorf("List, values: %+v, error: %v", values, err)
+	}
+
+	if count, err := ts.Delete("config/a"); count != 1 || err != nil {
+		t.Errorf("Delete, count: %d, error: %v", count, err)
+	}
+
+	// A value that is not a T is an error.
+	if err := kvs.Set("config/c", []byte(`"string"`)); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, err := ts.List("config/"); err == nil {
+		t.Errorf("List with wrong type did not return an error")
+	}
+}
+
+func TestTypedStoreZeroOptions(t *testing.T) {
+	ts := NewTyped[int](NewMemory(), nil)
+	if v, ok, err := ts.Get("k1"); v != 0 || ok || err != nil {
+		t.Errorf("Get missing key, value: %d, ok: %t, error: %v", v, ok, err)
+	}
+	if err := ts.Set("k1", 42); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if v, ok, err := ts.Get("k1"); v != 42 || !ok || err != nil {
+		t.Errorf("Get, value: %d, ok: %t, error: %v", v, ok, err)
+	}
+}
@@ -0,0 +1,221 @@
+package kvs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paulfdunn/go-helper/databaseh/v2"
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+const (
+	// insertIfAbsent inserts a value if the key does not exist, or has expired; the version of
+	// an expired key is incremented, and it is treated as created. Parameters are key, value,
+	// expires_at, the current time in Unix nanoseconds, and the store name.
+	insertIfAbsent = `INSERT INTO %s (key, value, expires_at, created_at, updated_at, version) VALUES (?1,?2,?3,?4,?4,
+		(SELECT COALESCE(MAX(deleted), 0) + 1 FROM kvs_revisions WHERE name=?5))
+		ON CONFLICT(key) DO UPDATE SET value=excluded.value, expires_at=excluded.expires_at, version=version+1,
+		content_type=NULL, created_at=excluded.created_at, updated_at=excluded.updated_at
+		WHERE expires_at IS NOT NULL AND expires_at <= ?4;`
+	// revisionsTable records, for each store, the highest version of a deleted key, so a key
+	// that is created again has a higher version than the deleted key.
+	revisionsTable = "kvs_revisions"
+	// revisionTriggerSuffix is added to the table name for the trigger that writes to
+	// revisionsTable.
+	revisionTriggerSuffix = "_revision_DELETE"
+	// upsert sets a value, incrementing the version of an existing key; new keys are version 1,
+	// or one more than the highest version of a deleted key. created_at is kept for existing
+	// keys. Parameters are key, value, expires_at, content_type, the current time in Unix
+	// nanoseconds, and the store name.
+	upsert = `INSERT INTO %s (key, value, expires_at, content_type, created_at, updated_at, version) VALUES (?1,?2,?3,?4,?5,?5,
+		(SELECT COALESCE(MAX(deleted), 0) + 1 FROM kvs_revisions WHERE name=?6))
+		ON CONFLICT(key) DO UPDATE SET value=excluded.value, expires_at=excluded.expires_at, version=version+1,
+		content_type=excluded.content_type, updated_at=excluded.updated_at;`
+)
+
+// ConflictError is returned by CompareAndSwap when the version of the key is not the
+// expected version. Actual is zero if the key does not exist.
+type ConflictError struct {
+	Actual   int64
+	Expected int64
+	Key      string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("kvs: version conflict for key: %s, expected: %d, actual: %d", e.Key, e.Expected, e.Actual)
+}
+
+// CompareAndSwap sets the value of key only if its version is expectedVersion; an
+// expectedVersion of zero requires that the key does not exist. Returns the new version, or
+// a *ConflictError if the version did not match. As with Set, the key does not expire.
+func (kvs KVS) CompareAndSwap(key string, expectedVersion int64, value []byte) (int64, error) {
+	return kvs.compareAndSwap(key, expectedVersion, value, nil)
+}
+
+// CompareAndSwapWithTTL is CompareAndSwap with a time-to-live, as SetWithTTL; I.E. to renew
+// a lock from SetIfNotExistsWithTTL.
+func (kvs KVS) CompareAndSwapWithTTL(key string, expectedVersion int64, value []byte, ttl time.Duration) (int64, error) {
+	return kvs.compareAndSwap(key, expectedVersion, value, expiresAt(ttl))
+}
+
+// GetWithVersion is Get, and also returns the version of the key; it is zero if the key does
+// not exist. The version is incremented by every change. Versions are not reused: a key is
+// created with a version one more than the highest version of any key deleted from the
+// store, or one, so CompareAndSwap with the version of a deleted key fails after the key is
+// created again.
+func (kvs KVS) GetWithVersion(key string) ([]byte, int64, error) {
+	if kvs.dbConn == nil {
+		return nil, 0, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	var value []byte
+	var version int64
+	row := kvs.readConn.QueryRow(fmt.Sprintf(`SELECT value, version FROM %s WHERE key=? AND %s;`, kvs.table, notExpired),
+		key, time.Now().UnixNano())
+	if err := row.Scan(&value, &version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, 0, nil
+		}
+		return nil, 0, runtimeh.SourceInfoError("scan error", err)
+	}
+	value, err := kvs.keyring.open(key, value)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, version, nil
+}
+
+// SetIfNotExists sets the value of key only if the key does not exist, or has expired;
+// returns true if the value was set.
+func (kvs KVS) SetIfNotExists(key string, value []byte) (bool, error) {
+	return kvs.setIfNotExists(key, value, nil)
+}
+
+// SetIfNotExistsWithTTL is SetIfNotExists with a time-to-live, as SetWithTTL. This can be
+// used as a lock, or for leader election, where the key expires if the holder does not
+// renew it with CompareAndSwapWithTTL, or delete it.
+func (kvs KVS) SetIfNotExistsWithTTL(key string, value []byte, ttl time.Duration) (bool, error) {
+	return kvs.setIfNotExists(key, value, expiresAt(ttl))
+}
+
+func (kvs KVS) setIfNotExists(key string, value []byte, expiresAt *int64) (bool, error) {
+	if kvs.dbConn == nil {
+		return false, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	value, err := kvs.keyring.seal(key, value)
+	if err != nil {
+		return false, err
+	}
+	defer kvs.cache.remove(key)
+	res, err := kvs.dbConn.Exec(fmt.Sprintf(insertIfAbsent, kvs.table), key, value, expiresAt, time.Now().UnixNano(), kvs.name)
+	if err != nil {
+		return false, runtimeh.SourceInfoError("", err)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, runtimeh.SourceInfoError("", err)
+	}
+	return count == 1, nil
+}
+
+func (kvs KVS) compareAndSwap(key string, expectedVersion int64, value []byte, expiresAt *int64) (int64, error) {
+	if kvs.dbConn == nil {
+		return 0, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+
+	value, err := kvs.keyring.seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+	defer kvs.cache.remove(key)
+	var row *sql.Row
+	now := time.Now().UnixNano()
+	if expectedVersion == 0 {
+		row = kvs.dbConn.QueryRow(fmt.Sprintf(strings.TrimSuffix(insertIfAbsent, ";")+" RETURNING version;", kvs.table),
+			key, value, expiresAt, now, kvs.name)
+	} else {
+		row = kvs.dbConn.QueryRow(fmt.Sprintf(`UPDATE %s SET value=?, expires_at=?, version=version+1,
+			content_type=NULL, updated_at=? WHERE key=? AND version=? AND %s RETURNING version;`, kvs.table, notExpired),
+			value, expiresAt, now, key, expectedVersion, now)
+	}
+	var version int64
+	err = row.Scan(&version)
+	if err == nil {
+		return version, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+
+	_, actual, err := kvs.GetWithVersion(key)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &ConflictError{Actual: actual, Expected: expectedVersion, Key: key}
+}
+
+// copyRevision sets the highest deleted version of the store dst to that of src, if it is
+// higher, using q, which is the database or a transaction.
+func copyRevision(q querier, src string, dst string) error {
+	_, err := q.Exec(`INSERT INTO kvs_revisions (name, deleted) SELECT ?, deleted FROM kvs_revisions WHERE name=?
+		ON CONFLICT(name) DO UPDATE SET deleted=MAX(deleted, excluded.deleted);`, dst, src)
+	return runtimeh.SourceInfoError("", err)
+}
+
+// createRevisions creates revisionsTable, if it does not exist, and the trigger that
+// records the versions of keys deleted from table, using q, which is the database or a
+// transaction. Dropping the table drops the trigger, but keeps the recorded version, so
+// versions are not reused if the store is created again.
+func createRevisions(q querier, table string) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS kvs_revisions (name TEXT NOT NULL PRIMARY KEY COLLATE NOCASE, deleted INTEGER NOT NULL);`,
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %s AFTER DELETE ON %s BEGIN
+			INSERT INTO kvs_revisions (name, deleted) VALUES (%s, OLD.version)
+			ON CONFLICT(name) DO UPDATE SET deleted=MAX(deleted, excluded.deleted);
+			END;`, databaseh.QuoteIdentifier(table+revisionTriggerSuffix), databaseh.QuoteIdentifier(table), quoteString(table)),
+	}
+	for _, query := range queries {
+		if _, err := q.Exec(query); err != nil {
+			return runtimeh.SourceInfoError("creating revisions", err)
+		}
+	}
+	return nil
+}
+
+// quoteString returns s as an SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// recordDropped records the highest version of the keys of table, which is to be dropped, as
+// dropping a table does not run the trigger from createRevisions; tables without the trigger
+// are not recorded.
+func recordDropped(q querier, table string) error {
+	var count int
+	row := q.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='trigger' AND name=?;`, table+revisionTriggerSuffix)
+	if err := row.Scan(&count); err != nil || count == 0 {
+		return runtimeh.SourceInfoError("", err)
+	}
+	_, err := q.Exec(fmt.Sprintf(`INSERT INTO kvs_revisions (name, deleted) SELECT ?, COALESCE(MAX(version), 0) FROM %s WHERE true
+		ON CONFLICT(name) DO UPDATE SET deleted=MAX(deleted, excluded.deleted);`, databaseh.QuoteIdentifier(table)), table)
+	return runtimeh.SourceInfoError("", err)
+}
+
+// renameRevisions moves the trigger, and the recorded version, of the store oldName to
+// newName, using q, which is the database or a transaction.
+func renameRevisions(q querier, oldName string, newName string) error {
+	if _, err := q.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s;`, databaseh.QuoteIdentifier(oldName+revisionTriggerSuffix))); err != nil {
+		return runtimeh.SourceInfoError("", err)
+	}
+	if err := createRevisions(q, newName); err != nil {
+		return err
+	}
+	if err := copyRevision(q, oldName, newName); err != nil {
+		return err
+	}
+	_, err := q.Exec(`DELETE FROM kvs_revisions WHERE name=?;`, oldName)
+	return runtimeh.SourceInfoError("", err)
+}
@@ -0,0 +1,242 @@
+package kvs
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	if b, version, err := kvs.GetWithVersion("k1"); err != nil || b != nil || version != 0 {
+		t.Errorf("GetWithVersion missing key, value: %s, version: %d, error: %v", b, version, err)
+	}
+	if err := kvs.Set("k1", []byte("v1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+		return
+	}
+	if err := kvs.Set("k1", []byte("v2")); err != nil {
+		t.Errorf("Set, error: %v", err)
+		return
+	}
+	b, version, err := kvs.GetWithVersion("k1")
+	if err != nil || string(b) != "v2" || version != 2 {
+		t.Errorf("GetWithVersion, value: %s, version: %d, error: %v", b, version, err)
+	}
+
+	if version, err = kvs.CompareAndSwap("k1", 2, []byte("v3")); err != nil || version != 3 {
+		t.Errorf("CompareAndSwap, version: %d, error: %v", version, err)
+	}
+	_, err = kvs.CompareAndSwap("k1", 2, []byte("v4"))
+	var ce *ConflictError
+	if !errors.As(err, &ce) || ce.Actual != 3 || ce.Expected != 2 || ce.Key != "k1" {
+		t.Errorf("CompareAndSwap stale version, error: %v", err)
+	}
+	if b, err := kvs.Get("k1"); err != nil || string(b) != "v3" {
+		t.Errorf("Get after conflict, value: %s, error: %v", b, err)
+	}
+
+	// Version zero requires the key does not exist.
+	if _, err = kvs.CompareAndSwap("k1", 0, []byte("v")); !errors.As(err, &ce) {
+		t.Errorf("CompareAndSwap existing key with version zero, error: %v", err)
+	}
+	if version, err = kvs.CompareAndSwap("k2", 0, []byte("v")); err != nil || version != 1 {
+		t.Errorf("CompareAndSwap new key, version: %d, error: %v", version, err)
+	}
+	if _, err = kvs.CompareAndSwap("k3", 1, []byte("v")); !errors.As(err, &ce) || ce.Actual != 0 {
+		t.Errorf("CompareAndSwap missing key, error: %v", err)
+	}
+}
+
+// TestCompareAndSwapConcurrent shows that only one of several concurrent updates, with the
+// same version, succeeds.
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName+"?_busy_timeout=5000", "testTable")
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+	if err := kvs.Set("k1", []byte("v")); err != nil {
+		t.Errorf("Set, error: %v", err)
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	mutex := sync.Mutex{}
+	succeeded := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := kvs.CompareAndSwap("k1", 1, []byte("changed"))
+			var ce *ConflictError
+			if err != nil && !errors.As(err, &ce) {
+				t.Errorf("CompareAndSwap, error: %v", err)
+			}
+			if err == nil {
+				mutex.Lock()
+				succeeded++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("succeeded: %d", succeeded)
+	}
+}
+
+func TestSetIfNotExists(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	if ok, err := kvs.SetIfNotExists("k1", []byte("v1")); !ok || err != nil {
+		t.Errorf("SetIfNotExists, ok: %t, error: %v", ok, err)
+	}
+	if ok, err := kvs.SetIfNotExists("k1", []byte("v2")); ok || err != nil {
+		t.Errorf("SetIfNotExists existing key, ok: %t, error: %v", ok, err)
+	}
+	if b, err := kvs.Get("k1"); err != nil || string(b) != "v1" {
+		t.Errorf("Get, value: %s, error: %v", b, err)
+	}
+
+	// A lock that expires can be taken by another holder.
+	if ok, err := kvs.SetIfNotExistsWithTTL("lock", []byte("a"), 50*time.Millisecond); !ok || err != nil {
+		t.Errorf("SetIfNotExistsWithTTL, ok: %t, error: %v", ok, err)
+	}
+	if ok, err := kvs.SetIfNotExistsWithTTL("lock", []byte("b"), time.Minute); ok || err != nil {
+		t.Errorf("SetIfNotExistsWithTTL held lock, ok: %t, error: %v", ok, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ok, err := kvs.SetIfNotExistsWithTTL("lock", []byte("b"), time.Minute); !ok || err != nil {
+		t.Errorf("SetIfNotExistsWithTTL expired lock, ok: %t, error: %v", ok, err)
+	}
+	b, version, err := kvs.GetWithVersion("lock")
+	if err != nil || string(b) != "b" || version != 2 {
+		t.Errorf("GetWithVersion, value: %s, version: %d, error: %v", b, version, err)
+	}
+	// The holder renews the lock.
+	if version, err = kvs.CompareAndSwapWithTTL("lock", version, []byte("b"), time.Minute); err != nil || version != 3 {
+		t.Errorf("CompareAndSwapWithTTL, version: %d, error: %v", version, err)
+	}
+}
+
+// TestCompareAndSwapDeleted checks that versions are not reused when a key is deleted and
+// created again, so a stale holder can not take a lock that was released and taken again.
+func TestCompareAndSwapDeleted(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	kvs, err := New(dataSourceName, "testTable")
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+
+	// A takes the lock, which is deleted, then B takes it.
+	if ok, err := kvs.SetIfNotExistsWithTTL("lock", []byte("A"), time.Minute); !ok || err != nil {
+		t.Errorf("SetIfNotExistsWithTTL, ok: %t, error: %v", ok, err)
+	}
+	_, versionA, err := kvs.GetWithVersion("lock")
+	if err != nil {
+		t.Errorf("GetWithVersion, error: %v", err)
+	}
+	if _, err := kvs.Delete("lock"); err != nil {
+		t.Errorf("Delete, error: %v", err)
+	}
+	if ok, err := kvs.SetIfNotExistsWithTTL("lock", []byte("B"), time.Minute); !ok || err != nil {
+		t.Errorf("SetIfNotExistsWithTTL, ok: %t, error: %v", ok, err)
+	}
+	_, versionB, err := kvs.GetWithVersion("lock")
+	if err != nil || versionB <= versionA {
+		t.Errorf("GetWithVersion, versionA: %d, versionB: %d, error: %v", versionA, versionB, err)
+	}
+	// A's renewal, with its stale version, fails.
+	_, err = kvs.CompareAndSwapWithTTL("lock", versionA, []byte("A"), time.Minute)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.Actual != versionB {
+		t.Errorf("CompareAndSwapWithTTL stale version, error: %v", err)
+	}
+	if b, err := kvs.Get("lock"); string(b) != "B" || err != nil {
+		t.Errorf("Get, value: %s, error: %v", b, err)
+	}
+
+	// Keys deleted by DeleteExpired, and Set, also do not reuse versions; the highest deleted
+	// version is kept when the store is renamed.
+	if err := kvs.SetWithTTL("lock", []byte("C"), time.Millisecond); err != nil {
+		t.Errorf("SetWithTTL, error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if count, err := kvs.DeleteExpired(10); count != 1 || err != nil {
+		t.Errorf("DeleteExpired, count: %d, error: %v", count, err)
+	}
+	kvs.Close()
+	ns, err := NewNamespaces(dataSourceName, nil)
+	if err != nil {
+		t.Fatalf("NewNamespaces, error: %v", err)
+	}
+	defer ns.Close()
+	if err := ns.Rename("testTable", "renamed"); err != nil {
+		t.Fatalf("Rename, error: %v", err)
+	}
+	kvs, err = New(dataSourceName, "renamed")
+	if err != nil {
+		t.Fatalf("New, error: %v", err)
+	}
+	defer kvs.Close()
+	if err := kvs.Set("lock", []byte("D")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, version, err := kvs.GetWithVersion("lock"); version != versionB+2 || err != nil {
+		t.Errorf("GetWithVersion after Rename, version: %d, want: %d, error: %v", version, versionB+2, err)
+	}
+
+	// Nor does dropping the store, and creating it again.
+	if err := kvs.DeleteStore(); err != nil {
+		t.Errorf("DeleteStore, error: %v", err)
+	}
+	if err := ns.Create("renamed"); err != nil {
+		t.Errorf("Create, error: %v", err)
+	}
+	if err := kvs.Set("lock", []byte("E")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, version, err := kvs.GetWithVersion("lock"); version != versionB+3 || err != nil {
+		t.Errorf("GetWithVersion after DeleteStore, version: %d, want: %d, error: %v", version, versionB+3, err)
+	}
+}
@@ -0,0 +1,253 @@
+package kvs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulfdunn/go-helper/databaseh/v2"
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// Op is the operation of an Event.
+type Op int
+
+const (
+	// OpPut is a key that was created or changed.
+	OpPut Op = iota + 1
+	// OpDelete is a key that was deleted, including expired keys deleted by DeleteExpired.
+	OpDelete
+)
+
+const (
+	// changeBatchSize is the maximum number of changes read from the change log per query.
+	changeBatchSize = 1000
+	// changeTableSuffix is added to the table name for the change log table.
+	changeTableSuffix = "_changes"
+	// createChangeTable creates the change log table; the parameter is the quoted table name.
+	createChangeTable = `CREATE TABLE IF NOT EXISTS %s (seq INTEGER PRIMARY KEY AUTOINCREMENT, key TEXT NOT NULL,
+		op INTEGER NOT NULL, value BLOB, version INTEGER, changed_at INTEGER NOT NULL);`
+)
+
+// Event is a change to a key, from Watch. For OpDelete, Value is nil and Version is the
+// version of the deleted key.
+type Event struct {
+	Key     string
+	Op      Op
+	Value   []byte
+	Version int64
+}
+
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("Op(%d)", op)
+}
+
+// DeleteChanges deletes change log entries older than age; returns the count deleted.
+// Watchers that have not read the deleted entries miss those events.
+func (kvs KVS) DeleteChanges(age time.Duration) (int64, error) {
+	if kvs.dbConn == nil {
+		return 0, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	if err := kvs.createChangeLog(); err != nil {
+		return 0, err
+	}
+
+	res, err := kvs.dbConn.Exec(fmt.Sprintf(`DELETE FROM %s WHERE changed_at < ?;`, kvs.changeTable()),
+		time.Now().Add(-age).UnixNano())
+	if err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	count, err := res.RowsAffected()
+	return count, runtimeh.SourceInfoError("", err)
+}
+
+// Watch returns a channel of events for changes to keys that start with prefix; an empty
+// prefix watches all keys. Changes are recorded, by triggers, in a change log table that is
+// polled every interval, so changes made by other processes using the same database file
+// are also delivered. Only changes made after Watch is called are delivered, in the order
+// they were made. It is an error if interval is not greater than zero. Call the returned
+// function to stop watching; the channel is then closed.
+//
+// The change log, and triggers, are created by the first call to Watch, and from then on
+// every change to the table is logged; use DeleteChanges to limit the size of the log.
+func (kvs KVS) Watch(prefix string, interval time.Duration) (<-chan Event, func(), error) {
+	if kvs.dbConn == nil {
+		return nil, nil, fmt.Errorf("%s kvs db is nil", runtimeh.SourceInfo())
+	}
+	if interval <= 0 {
+		return nil, nil, fmt.Errorf("%s interval must be greater than zero", runtimeh.SourceInfo())
+	}
+	if err := kvs.createChangeLog(); err != nil {
+		return nil, nil, err
+	}
+
+	var seq int64
+	row := kvs.readConn.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(seq), 0) FROM %s;`, kvs.changeTable()))
+	if err := row.Scan(&seq); err != nil {
+		return nil, nil, runtimeh.SourceInfoError("", err)
+	}
+
+	events := make(chan Event, changeBatchSize)
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			var err error
+			if seq, err = kvs.sendChanges(prefix, seq, events, done); err != nil {
+				fmt.Printf("kvs watch error:%+v\n", err)
+			}
+		}
+	}()
+
+	once := sync.Once{}
+	return events, func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}, nil
+}
+
+// changeTable is the quoted name of the change log table for the KVS.
+func (kvs KVS) changeTable() string {
+	return databaseh.QuoteIdentifier(changeTableName(kvs.name))
+}
+
+// changeTableName is the name of the change log table for the table.
+func changeTableName(table string) string {
+	return table + changeTableSuffix
+}
+
+// changeTriggerNames are the names of the index and triggers of the change log for table.
+func changeTriggerNames(table string) (string, []string) {
+	changes := changeTableName(table)
+	return changes + "_changed_at", []string{changes + "_INSERT", changes + "_UPDATE", changes + "_DELETE"}
+}
+
+// createChangeLog creates the change log table, and the triggers that write to it.
+func (kvs KVS) createChangeLog() error {
+	return createChangeLog(kvs.dbConn, kvs.name)
+}
+
+// createChangeLog creates the change log table for table, and the triggers that write to
+// it, using q, which is the database or a transaction. It is an error if a table with the
+// name of the change log exists, and is not a change log.
+func createChangeLog(q querier, table string) error {
+	if exists, isChangeLog, err := findChangeLog(q, table); err != nil || (exists && !isChangeLog) {
+		if err == nil {
+			err = fmt.Errorf("%s table %q exists, and is not a change log", runtimeh.SourceInfo(), changeTableName(table))
+		}
+		return err
+	}
+	changes := databaseh.QuoteIdentifier(changeTableName(table))
+	index, triggers := changeTriggerNames(table)
+	queries := []string{
+		fmt.Sprintf(createChangeTable, changes),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (changed_at);`, databaseh.QuoteIdentifier(index), changes),
+	}
+	for i, trigger := range []struct {
+		event string
+		row   string
+		op    Op
+		value string
+	}{
+		{"INSERT", "NEW", OpPut, "NEW.value"},
+		{"UPDATE", "NEW", OpPut, "NEW.value"},
+		{"DELETE", "OLD", OpDelete, "NULL"},
+	} {
+		queries = append(queries, fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %s AFTER %s ON %s BEGIN
+			INSERT INTO %s (key, op, value, version, changed_at)
+			VALUES (%s.key, %d, %s, %s.version, CAST((julianday('now') - 2440587.5) * 86400000000000 AS INTEGER));
+			END;`, databaseh.QuoteIdentifier(triggers[i]), trigger.event, databaseh.QuoteIdentifier(table), changes,
+			trigger.row, trigger.op, trigger.value, trigger.row))
+	}
+
+	for _, query := range queries {
+		if _, err := q.Exec(query); err != nil {
+			return runtimeh.SourceInfoError("creating change log", err)
+		}
+	}
+	return nil
+}
+
+// findChangeLog returns true if a table with the name of the change log of table exists,
+// and true if it is a change log; it is not if it is a store, I.E. one created with New
+// before the name was reserved.
+func findChangeLog(q querier, table string) (bool, bool, error) {
+	changes := changeTableName(table)
+	exists, err := tableExists(q, changes)
+	if err != nil || !exists {
+		return false, false, err
+	}
+	var columns int
+	row := q.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name IN ('seq', 'op', 'changed_at');`, changes)
+	if err := row.Scan(&columns); err != nil {
+		return true, false, runtimeh.SourceInfoError("", err)
+	}
+	return true, columns == 3, nil
+}
+
+// sendChanges sends events, for changes after seq to keys starting with prefix, until there
+// are no more changes or done is closed; returns the seq of the last change read.
+func (kvs KVS) sendChanges(prefix string, seq int64, events chan<- Event, done <-chan struct{}) (int64, error) {
+	query := fmt.Sprintf(`SELECT seq, key, op, value, version FROM %s WHERE seq > ? ORDER BY seq LIMIT ?;`, kvs.changeTable())
+	for {
+		rows, err := kvs.readConn.Query(query, seq, changeBatchSize)
+		if err != nil {
+			return seq, runtimeh.SourceInfoError("", err)
+		}
+		batch := []Event{}
+		read := 0
+		for rows.Next() {
+			read++
+			event := Event{}
+			if err := rows.Scan(&seq, &event.Key, &event.Op, &event.Value, &event.Version); err != nil {
+				rows.Close()
+				return seq, runtimeh.SourceInfoError("scan error", err)
+			}
+			if !strings.HasPrefix(event.Key, prefix) {
+				continue
+			}
+			var err error
+			if event.Value, err = kvs.keyring.open(event.Key, event.Value); err != nil {
+				rows.Close()
+				return seq, err
+			}
+			batch = append(batch, event)
+		}
+		// Rows are closed before sending, so the connection is not held while blocked.
+		err = rows.Err()
+		if cerr := rows.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return seq, runtimeh.SourceInfoError("scan iteration error", err)
+		}
+
+		for _, event := range batch {
+			select {
+			case events <- event:
+			case <-done:
+				return seq, nil
+			}
+		}
+		if read < changeBatchSize {
+			return seq, nil
+		}
+	}
+}
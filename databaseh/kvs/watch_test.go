@@ -0,0 +1,100 @@
+package kvs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	if err := testSetup(); err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("testSetup error; %+v", err)
+		}
+	}
+
+	table := "testTable"
+	kvs, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer kvs.Close()
+	// Changes before Watch is called are not delivered.
+	if err := kvs.Set("user/0", []byte("before")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+
+	if _, _, err := kvs.Watch("user/", 0); err == nil {
+		t.Errorf("Watch with zero interval did not return an error")
+	}
+	events, stop, err := kvs.Watch("user/", 10*time.Millisecond)
+	if err != nil {
+		t.Errorf("Watch, error: %v", err)
+		return
+	}
+	// other is a second connection to the database, as if from another process.
+	other, err := New(dataSourceName, table)
+	if err != nil {
+		t.Errorf("New, error: %v", err)
+		return
+	}
+	defer other.Close()
+
+	if err := kvs.Set("user/1", []byte("v1")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := kvs.Set("config", []byte("v")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if err := kvs.Set("user/1", []byte("v2")); err != nil {
+		t.Errorf("Set, error: %v", err)
+	}
+	if _, err := kvs.Delete("user/1"); err != nil {
+		t.Errorf("Delete, error: %v", err)
+	}
+	if err := other.SetMany(map[string][]byte{"user/2": []byte("other")}); err != nil {
+		t.Errorf("SetMany, error: %v", err)
+	}
+
+	want := []Event{
+		{Key: "user/1", Op: OpPut, Value: []byte("v1"), Version: 1},
+		{Key: "user/1", Op: OpPut, Value: []byte("v2"), Version: 2},
+		{Key: "user/1", Op: OpDelete, Version: 2},
+		// Versions are not reused, so a key created after user/1 was deleted has a higher version.
+		{Key: "user/2", Op: OpPut, Value: []byte("other"), Version: 3},
+	}
+	got := []Event{}
+	timeout := time.After(5 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case event := <-events:
+			got = append(got, event)
+		case <-timeout:
+			t.Fatalf("timeout, events: %+v", got)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events: %+v, want: %+v", got, want)
+	}
+
+	stop()
+	stop()
+	if _, ok := <-events; ok {
+		t.Errorf("events channel not closed")
+	}
+
+	if count, err := kvs.DeleteChanges(0); err != nil || count != 5 {
+		t.Errorf("DeleteChanges, count: %d, error: %v", count, err)
+	}
+	if err := kvs.DeleteStore(); err != nil {
+		t.Errorf("DeleteStore, error: %v", err)
+	}
+}
+
+func TestOpString(t *testing.T) {
+	if OpPut.String() != "put" || OpDelete.String() != "delete" || Op(9).String() != "Op(9)" {
+		t.Errorf("Op.String")
+	}
+}
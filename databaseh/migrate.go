@@ -0,0 +1,164 @@
+package databaseh
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
+)
+
+// MigrationsTable is the table that records the version of each schema.
+const MigrationsTable = "databaseh_migrations"
+
+var createMigrationsTable = fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (schema TEXT NOT NULL PRIMARY KEY,
+	version INTEGER NOT NULL, updated_at INTEGER NOT NULL);`, MigrationsTable)
+
+// Migration is one versioned change to a schema; I.E. creating a table, or adding a column.
+type Migration struct {
+	Description string
+	// Up makes the change, in the transaction of Migrate.
+	Up func(tx *sql.Tx) error
+	// Version must be greater than zero, and greater than the Version of the previous
+	// Migration.
+	Version int
+}
+
+// Migrate applies the migrations with a Version greater than the recorded version of the
+// schema, in order, and records the new version; returns the version. A schema is any name
+// for a set of tables, I.E. the name of a table. All migrations are applied in a single
+// transaction, so if any migration fails, none are applied and the recorded version is
+// unchanged. The transaction takes the write lock before reading the version, so concurrent
+// calls, I.E. from other processes, wait rather than both applying the migrations.
+func Migrate(db *sql.DB, schema string, migrations []Migration) (int, error) {
+	// The table is created before the transaction; in the transaction, the first statement
+	// must be a write, so the busy timeout applies when taking the write lock. A read first
+	// would return "database is locked" when there are concurrent calls.
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, runtimeh.SourceInfoError("begin transaction", err)
+	}
+	version, err := migrate(tx, schema, migrations)
+	if err != nil {
+		if rerr := tx.Rollback(); rerr != nil {
+			fmt.Printf("tx.Rollback() error:%+v\n", rerr)
+		}
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, runtimeh.SourceInfoError("commit transaction", err)
+	}
+	return version, nil
+}
+
+// MigrateTx is Migrate in a transaction owned by the caller, so migrations can be combined
+// with other changes; the caller must roll back the transaction if an error is returned.
+// Concurrent calls may return "database is locked", unless the transaction was started with
+// a write or is an immediate transaction.
+func MigrateTx(tx *sql.Tx, schema string, migrations []Migration) (int, error) {
+	if _, err := tx.Exec(createMigrationsTable); err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	return migrate(tx, schema, migrations)
+}
+
+// migrate implements MigrateTx, when MigrationsTable exists.
+func migrate(tx *sql.Tx, schema string, migrations []Migration) (int, error) {
+	for i, m := range migrations {
+		if m.Version <= 0 || (i > 0 && m.Version <= migrations[i-1].Version) {
+			return 0, fmt.Errorf("%s migration versions must be greater than zero and increasing, schema: %s, version: %d",
+				runtimeh.SourceInfo(), schema, m.Version)
+		}
+	}
+
+	// The INSERT takes the write lock, whether or not the row exists.
+	query := fmt.Sprintf(`INSERT OR IGNORE INTO %s (schema, version, updated_at) VALUES (?, 0, ?);`, MigrationsTable)
+	if _, err := tx.Exec(query, schema, time.Now().UnixNano()); err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	version, err := schemaVersion(tx, schema)
+	if err != nil {
+		return 0, err
+	}
+
+	current := version
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := m.Up(tx); err != nil {
+			return 0, runtimeh.SourceInfoError(fmt.Sprintf("migrating schema: %s, version: %d, %s", schema, m.Version, m.Description), err)
+		}
+		version = m.Version
+	}
+	if version == current {
+		return version, nil
+	}
+	_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET version=?, updated_at=? WHERE schema=?;`, MigrationsTable),
+		version, time.Now().UnixNano(), schema)
+	if err != nil {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	return version, nil
+}
+
+// DeleteSchema deletes the recorded version of a schema, I.E. when its tables are dropped.
+func DeleteSchema(tx *sql.Tx, schema string) error {
+	if exists, err := migrationsTableExists(tx); err != nil || !exists {
+		return err
+	}
+	_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE schema=?;`, MigrationsTable), schema)
+	return runtimeh.SourceInfoError("", err)
+}
+
+// RenameSchema changes the name of a schema, keeping its version, I.E. when its table is
+// renamed.
+func RenameSchema(tx *sql.Tx, oldSchema string, newSchema string) error {
+	if exists, err := migrationsTableExists(tx); err != nil || !exists {
+		return err
+	}
+	_, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET schema=?, updated_at=? WHERE schema=?;`, MigrationsTable),
+		newSchema, time.Now().UnixNano(), oldSchema)
+	return runtimeh.SourceInfoError("", err)
+}
+
+// SchemaVersion returns the recorded version of a schema; zero if no migrations have been
+// applied.
+func SchemaVersion(db *sql.DB, schema string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, runtimeh.SourceInfoError("begin transaction", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			fmt.Printf("tx.Rollback() error:%+v\n", err)
+		}
+	}()
+	if exists, err := migrationsTableExists(tx); err != nil || !exists {
+		return 0, err
+	}
+	return schemaVersion(tx, schema)
+}
+
+// migrationsTableExists returns true if MigrationsTable exists.
+func migrationsTableExists(tx *sql.Tx) (bool, error) {
+	var count int
+	row := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?;`, MigrationsTable)
+	if err := row.Scan(&count); err != nil {
+		return false, runtimeh.SourceInfoError("", err)
+	}
+	return count > 0, nil
+}
+
+// schemaVersion returns the recorded version of a schema; zero if there is none.
+func schemaVersion(tx *sql.Tx, schema string) (int, error) {
+	var version int
+	row := tx.QueryRow(fmt.Sprintf(`SELECT version FROM %s WHERE schema=?;`, MigrationsTable), schema)
+	if err := row.Scan(&version); err != nil && err != sql.ErrNoRows {
+		return 0, runtimeh.SourceInfoError("", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,151 @@
+package databaseh
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open, error: %v", err)
+	}
+	defer db.Close()
+
+	if version, err := SchemaVersion(db, "test"); version != 0 || err != nil {
+		t.Errorf("SchemaVersion before Migrate, version: %d, error: %v", version, err)
+	}
+
+	applied := []int{}
+	migrations := []Migration{
+		testMigration(&applied, 1, `CREATE TABLE test (id INTEGER PRIMARY KEY);`),
+		testMigration(&applied, 2, `ALTER TABLE test ADD COLUMN name TEXT;`),
+	}
+	if version, err := Migrate(db, "test", migrations); version != 2 || err != nil {
+		t.Errorf("Migrate, version: %d, error: %v", version, err)
+	}
+	// Applied migrations are not applied again.
+	if version, err := Migrate(db, "test", migrations); version != 2 || err != nil {
+		t.Errorf("Migrate again, version: %d, error: %v", version, err)
+	}
+	migrations = append(migrations, testMigration(&applied, 5, `ALTER TABLE test ADD COLUMN size INTEGER;`))
+	if version, err := Migrate(db, "test", migrations); version != 5 || err != nil {
+		t.Errorf("Migrate added migration, version: %d, error: %v", version, err)
+	}
+	if fmt.Sprint(applied) != "[1 2 5]" {
+		t.Errorf("applied: %v", applied)
+	}
+	if version, err := SchemaVersion(db, "test"); version != 5 || err != nil {
+		t.Errorf("SchemaVersion, version: %d, error: %v", version, err)
+	}
+
+	// A failed migration rolls back all migrations in the call.
+	errFailed := errors.New("failed")
+	failing := append(migrations,
+		testMigration(&applied, 6, `CREATE TABLE other (id INTEGER);`),
+		Migration{Description: "fail", Up: func(tx *sql.Tx) error { return errFailed }, Version: 7})
+	if _, err := Migrate(db, "test", failing); !errors.Is(err, errFailed) {
+		t.Errorf("Migrate failing, error: %v", err)
+	}
+	if version, err := SchemaVersion(db, "test"); version != 5 || err != nil {
+		t.Errorf("SchemaVersion after failure, version: %d, error: %v", version, err)
+	}
+	if _, err := db.Exec(`SELECT * FROM other;`); err == nil {
+		t.Errorf("migration before the failure was not rolled back")
+	}
+
+	for _, invalid := range [][]Migration{
+		{{Version: 0}},
+		{{Version: 2}, {Version: 1}},
+		{{Version: 1}, {Version: 1}},
+	} {
+		if _, err := Migrate(db, "invalid", invalid); err == nil {
+			t.Errorf("Migrate invalid versions did not return an error")
+		}
+	}
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	dataSourceName := filepath.Join(t.TempDir(), "test.db")
+	var applied int32
+	migrations := []Migration{{Description: "create", Version: 1, Up: func(tx *sql.Tx) error {
+		atomic.AddInt32(&applied, 1)
+		_, err := tx.Exec(`CREATE TABLE test (id INTEGER PRIMARY KEY);`)
+		return err
+	}}}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each db is as if from another process.
+			db, err := Open(dataSourceName)
+			if err != nil {
+				t.Errorf("Open, error: %v", err)
+				return
+			}
+			defer db.Close()
+			if version, err := Migrate(db, "test", migrations); version != 1 || err != nil {
+				t.Errorf("Migrate, version: %d, error: %v", version, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if applied != 1 {
+		t.Errorf("migration applied %d times", applied)
+	}
+}
+
+func TestRenameDeleteSchema(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Open, error: %v", err)
+	}
+	defer db.Close()
+
+	update := func(fn func(tx *sql.Tx) error) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Begin, error: %v", err)
+		}
+		if err := fn(tx); err != nil {
+			t.Errorf("error: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Errorf("Commit, error: %v", err)
+		}
+	}
+	// Without the migrations table, there is nothing to change.
+	update(func(tx *sql.Tx) error { return RenameSchema(tx, "old", "new") })
+
+	applied := []int{}
+	if _, err := Migrate(db, "old", []Migration{testMigration(&applied, 3, `SELECT 1;`)}); err != nil {
+		t.Errorf("Migrate, error: %v", err)
+	}
+	update(func(tx *sql.Tx) error { return RenameSchema(tx, "old", "new") })
+	if version, err := SchemaVersion(db, "new"); version != 3 || err != nil {
+		t.Errorf("SchemaVersion renamed, version: %d, error: %v", version, err)
+	}
+	if version, err := SchemaVersion(db, "old"); version != 0 || err != nil {
+		t.Errorf("SchemaVersion old, version: %d, error: %v", version, err)
+	}
+	update(func(tx *sql.Tx) error { return DeleteSchema(tx, "new") })
+	if version, err := SchemaVersion(db, "new"); version != 0 || err != nil {
+		t.Errorf("SchemaVersion deleted, version: %d, error: %v", version, err)
+	}
+}
+
+// testMigration returns a Migration that executes query, and appends the version to applied.
+func testMigration(applied *[]int, version int, query string) Migration {
+	return Migration{Description: query, Version: version, Up: func(tx *sql.Tx) error {
+		*applied = append(*applied, version)
+		_, err := tx.Exec(query)
+		return err
+	}}
+}
@@ -3,12 +3,41 @@ package databaseh
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/paulfdunn/go-helper/osh/v2/runtimeh"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Options are SQLite settings for OpenWithOptions; zero values use the SQLite, or driver,
+// defaults. Settings are applied to every connection in the pool.
+type Options struct {
+	// BusyTimeout is how long a connection waits for a lock held by another connection
+	// before returning "database is locked"; the driver default is 5 seconds.
+	BusyTimeout time.Duration
+	// CacheSize is PRAGMA cache_size: pages if positive, or KiB if negative.
+	CacheSize int
+	// JournalMode is PRAGMA journal_mode: DELETE, TRUNCATE, PERSIST, MEMORY, WAL, or OFF.
+	// WAL allows readers to run while there is a writer.
+	JournalMode string
+	// MaxOpenConns is the maximum number of connections in the pool; zero is unlimited.
+	MaxOpenConns int
+	// QueryOnly is PRAGMA query_only, which prevents all changes to the database.
+	QueryOnly bool
+	// Synchronous is PRAGMA synchronous: OFF, NORMAL, FULL, or EXTRA. NORMAL is safe, and
+	// faster than FULL, with WAL.
+	Synchronous string
+	// TxLock is the locking of transactions: deferred, immediate, or exclusive. Immediate
+	// takes the write lock at the start of a transaction, rather than when the first write
+	// is made, so the busy timeout applies rather than failing to upgrade the lock.
+	TxLock string
+}
+
 func Open(dataSourceName string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", dataSourceName)
 	if err != nil {
@@ -18,3 +47,77 @@ func Open(dataSourceName string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// OpenWithOptions is Open with options, which are added to the dataSourceName; a nil
+// options is the same as Open. The database is pinged, so invalid options are returned as
+// errors here rather than on first use.
+func OpenWithOptions(dataSourceName string, options *Options) (*sql.DB, error) {
+	if options == nil {
+		return Open(dataSourceName)
+	}
+	db, err := Open(dataSourceName + options.query(dataSourceName))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	if err := db.Ping(); err != nil {
+		if cerr := db.Close(); cerr != nil {
+			fmt.Printf("db.Close() error:%+v\n", cerr)
+		}
+		return nil, runtimeh.SourceInfoError("applying options", err)
+	}
+	return db, nil
+}
+
+// QuoteIdentifier returns name quoted as an SQL identifier, I.E. a table name, so it can be
+// used in a query; use ValidateIdentifier first for names from user input.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// ValidateIdentifier returns an error if name can not be used as an SQL identifier, with
+// QuoteIdentifier: it is empty, longer than 255 bytes, not UTF-8, contains a NUL, or starts
+// with "sqlite_", which is reserved by SQLite. Other names, I.E. with dashes, spaces, or
+// quotes, are valid when quoted.
+func ValidateIdentifier(name string) error {
+	switch {
+	case name == "" || len(name) > 255:
+		return fmt.Errorf("%s identifier length must be 1 to 255 bytes, name: %q", runtimeh.SourceInfo(), name)
+	case !utf8.ValidString(name) || strings.ContainsRune(name, 0):
+		return fmt.Errorf("%s identifier is not valid UTF-8, or contains a NUL, name: %q", runtimeh.SourceInfo(), name)
+	case strings.HasPrefix(strings.ToLower(name), "sqlite_"):
+		return fmt.Errorf("%s identifier starting with sqlite_ is reserved, name: %q", runtimeh.SourceInfo(), name)
+	}
+	return nil
+}
+
+// query returns the query string for the options, starting with "?", or "&" if
+// dataSourceName already has a query string; or an empty string if there are no options.
+func (options *Options) query(dataSourceName string) string {
+	params := url.Values{}
+	if options.BusyTimeout > 0 {
+		params.Set("_busy_timeout", fmt.Sprint(options.BusyTimeout.Milliseconds()))
+	}
+	if options.CacheSize != 0 {
+		params.Set("_cache_size", fmt.Sprint(options.CacheSize))
+	}
+	if options.JournalMode != "" {
+		params.Set("_journal_mode", options.JournalMode)
+	}
+	if options.QueryOnly {
+		params.Set("_query_only", "true")
+	}
+	if options.Synchronous != "" {
+		params.Set("_synchronous", options.Synchronous)
+	}
+	if options.TxLock != "" {
+		params.Set("_txlock", options.TxLock)
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	if strings.Contains(dataSourceName, "?") {
+		return "&" + params.Encode()
+	}
+	return "?" + params.Encode()
+}
@@ -0,0 +1,68 @@
+package databaseh
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenWithOptions(t *testing.T) {
+	dataSourceName := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenWithOptions(dataSourceName, &Options{
+		BusyTimeout:  2 * time.Second,
+		CacheSize:    -4096,
+		JournalMode:  "WAL",
+		MaxOpenConns: 2,
+		Synchronous:  "NORMAL",
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions, error: %v", err)
+	}
+	defer db.Close()
+
+	pragmas := map[string]string{
+		"busy_timeout": "2000",
+		"cache_size":   "-4096",
+		"journal_mode": "wal",
+		"synchronous":  "1",
+	}
+	for pragma, want := range pragmas {
+		var got string
+		if err := db.QueryRow("PRAGMA " + pragma + ";").Scan(&got); err != nil || got != want {
+			t.Errorf("PRAGMA %s, got: %s, want: %s, error: %v", pragma, got, want, err)
+		}
+	}
+	if stats := db.Stats(); stats.MaxOpenConnections != 2 {
+		t.Errorf("MaxOpenConnections: %d", stats.MaxOpenConnections)
+	}
+
+	// Options are added to an existing query string.
+	readOnly, err := OpenWithOptions(dataSourceName+"?_busy_timeout=100", &Options{QueryOnly: true})
+	if err != nil {
+		t.Fatalf("OpenWithOptions, error: %v", err)
+	}
+	defer readOnly.Close()
+	if _, err := readOnly.Exec(`CREATE TABLE test (id INTEGER);`); err == nil {
+		t.Errorf("CREATE TABLE on a query only connection did not return an error")
+	}
+
+	if _, err := OpenWithOptions(dataSourceName, &Options{Synchronous: "SOMETIMES"}); err == nil {
+		t.Errorf("OpenWithOptions with invalid option did not return an error")
+	}
+}
+
+func TestIdentifiers(t *testing.T) {
+	if got := QuoteIdentifier(`a"b`); got != `"a""b"` {
+		t.Errorf("QuoteIdentifier: %s", got)
+	}
+	for _, name := range []string{"table", "with-dash", `quote"d`, "unicodé"} {
+		if err := ValidateIdentifier(name); err != nil {
+			t.Errorf("ValidateIdentifier, name: %s, error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "sqlite_master", "a\x00b", "\xff", string(make([]byte, 256))} {
+		if err := ValidateIdentifier(name); err == nil {
+			t.Errorf("ValidateIdentifier did not return an error, name: %q", name)
+		}
+	}
+}